@@ -0,0 +1,75 @@
+// mockserver 独立的Mock服务，加载规则文件并通过HTTP提供Mock，便于非Go团队以二进制方式使用mock包
+//
+// Usage:
+//
+//	mockserver -rules rules.yaml -listen :8080 -admin :8081 -upstream http://127.0.0.1:9000 -watch 2s
+//
+// 1. 规则文件格式同mock.LoadRules，-watch大于0时热加载;
+// 2. 未匹配或匹配到透明规则的请求转发到-upstream，未指定时返回404;
+// 3. -admin指定时在该地址提供mock.NewAdminHandler管理API;
+// 4. 访问日志为json格式，包含请求ID(X-Request-Id)、特征值和匹配的规则
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	var (
+		cfg     config
+		listen  string
+		admin   string
+		logPath string
+	)
+	flag.StringVar(&cfg.Rules, "rules", "rules.yaml", "rule file, json or yaml")
+	flag.StringVar(&cfg.Extractor, "extractor", "", "eigenkey extractor config in json, default extractor if empty")
+	flag.StringVar(&cfg.Session, "session", "", `scenario session key config in json, e.g. {"header": "X-Mock-Session"}`)
+	flag.StringVar(&cfg.Upstream, "upstream", "", "fallback upstream for unmatched and transparent requests, 404 if empty")
+	flag.DurationVar(&cfg.Watch, "watch", 2*time.Second, "rule file check interval, 0 to disable hot reload")
+	flag.StringVar(&listen, "listen", ":8080", "mock listen address")
+	flag.StringVar(&admin, "admin", "", "admin api listen address, disabled if empty")
+	flag.StringVar(&logPath, "log", "", "access log file, stdout if empty")
+	flag.Parse()
+
+	logger := newLogger(logPath, os.Stdout)
+	defer logger.Sync()
+
+	s, err := newServer(cfg, logger)
+	if err != nil {
+		logger.Fatal("init mock server failed", zap.Error(err))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go s.run(ctx)
+
+	servers := []*http.Server{{Addr: listen, Handler: s.handler}}
+	if admin != "" {
+		servers = append(servers, &http.Server{Addr: admin, Handler: s.admin})
+	}
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			logger.Info("listening", zap.String("addr", srv.Addr))
+			err := srv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logger.Error("serve failed", zap.String("addr", srv.Addr), zap.Error(err))
+				stop()
+			}
+		}(srv)
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		_ = srv.Shutdown(shutdownCtx)
+	}
+	logger.Info("mock server stopped")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/logkit"
+	"github.com/ccmonky/pkg/mock"
+	"github.com/ccmonky/pkg/utils"
+)
+
+// RequestIDHeader 请求ID头，请求未携带时生成ulid，并在响应中返回
+const RequestIDHeader = "X-Request-Id"
+
+// config mockserver配置
+type config struct {
+	Rules     string        // NOTE: 规则文件，json或yaml，格式同mock.LoadRules
+	Extractor string        // NOTE: eigenkey.HTTPRequestEigenkeyExtractor的json配置，为空时使用默认配置
+	Session   string        // NOTE: mock.SessionKey的json配置，为空时所有请求共享场景状态
+	Upstream  string        // NOTE: 未匹配或透明规则转发的上游，为空时返回404
+	Watch     time.Duration // NOTE: 规则文件检查间隔，0表示不热加载
+}
+
+// server 由RuleMatcher、mock.Handler和管理API组成
+type server struct {
+	matcher *mock.RuleMatcher
+	watcher *mock.RuleWatcher
+	handler http.Handler
+	admin   http.Handler
+	logger  *zap.Logger
+	watch   time.Duration
+}
+
+func newServer(cfg config, logger *zap.Logger) (*server, error) {
+	extractor := &eigenkey.HTTPRequestEigenkeyExtractor{}
+	if cfg.Extractor != "" {
+		err := json.Unmarshal([]byte(cfg.Extractor), extractor)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unmarshal extractor failed")
+		}
+	}
+	matcher := &mock.RuleMatcher{Extractor: extractor}
+	if cfg.Session != "" {
+		matcher.Session = &mock.SessionKey{}
+		err := json.Unmarshal([]byte(cfg.Session), matcher.Session)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unmarshal session failed")
+		}
+	}
+	err := matcher.Provision()
+	if err != nil {
+		return nil, err
+	}
+	watcher := mock.NewRuleWatcher(cfg.Rules, matcher)
+	watcher.Interval = cfg.Watch
+	watcher.OnError = func(err error) {
+		logger.Error("reload rules failed", zap.String("path", cfg.Rules), zap.Error(err))
+	}
+	watcher.OnReload = func([]byte) {
+		logger.Info("rules loaded", zap.String("path", cfg.Rules), zap.Int("count", len(matcher.GetRules())))
+	}
+	err = watcher.Load()
+	if err != nil {
+		return nil, err
+	}
+	var target *url.URL
+	if cfg.Upstream != "" {
+		target, err = url.Parse(cfg.Upstream)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse upstream %s failed", cfg.Upstream)
+		}
+	}
+	handler := mock.NewHandler(&accessMatcher{RuleMatcher: matcher}, target)
+	handler.ErrorLog = zap.NewStdLog(logger)
+	return &server{
+		matcher: matcher,
+		watcher: watcher,
+		handler: accessLog(logger, handler),
+		admin:   accessLog(logger, mock.NewAdminHandler(matcher)),
+		logger:  logger,
+		watch:   cfg.Watch,
+	}, nil
+}
+
+// run 热加载规则直到ctx结束
+func (s *server) run(ctx context.Context) {
+	if s.watch <= 0 {
+		return
+	}
+	_ = s.watcher.Watch(ctx)
+}
+
+// newLogger 新建json格式的zap.Logger，path为空时输出到stdout，否则使用logkit.Logger按大小滚动
+func newLogger(path string, stdout io.Writer) *zap.Logger {
+	var ws zapcore.WriteSyncer = zapcore.AddSync(stdout)
+	if path != "" {
+		ws = zapcore.AddSync(&logkit.Logger{Filename: path, MaxSize: 100, MaxBackups: 10})
+	}
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), ws, zap.InfoLevel)
+	return zap.New(core)
+}
+
+// accessInfo 请求处理过程中记录的匹配信息，供访问日志使用
+type accessInfo struct {
+	eigenkey string
+	rule     string
+	matched  bool
+}
+
+type ctxKeyAccessInfo struct{}
+
+// accessMatcher 包装RuleMatcher，将匹配结果记录到context中的accessInfo
+type accessMatcher struct {
+	*mock.RuleMatcher
+}
+
+func (m *accessMatcher) Match(r *http.Request) (string, mock.ResponseMocker, error) {
+	key, rule, err := m.MatchRule(r)
+	if err != nil || rule == nil {
+		return key, nil, err
+	}
+	return key, rule.Mocker, nil
+}
+
+// MatchRule 覆盖RuleMatcher.MatchRule，使通过mock.RuleSelector调用时同样记录匹配结果
+func (m *accessMatcher) MatchRule(r *http.Request) (string, *mock.Rule, error) {
+	key, rule, err := m.RuleMatcher.MatchRule(r)
+	info, _ := r.Context().Value(ctxKeyAccessInfo{}).(*accessInfo)
+	if info != nil {
+		info.eigenkey = key
+		if err == nil && rule != nil {
+			info.rule, info.matched = rule.Name, true
+		}
+	}
+	return key, rule, err
+}
+
+// accessLog 访问日志中间件，为每个请求分配请求ID，写入context(utils.RequestIDKey)和响应头
+func accessLog(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := r.Header.Get(RequestIDHeader)
+		if reqID == "" {
+			reqID = utils.MustUlid()
+			r.Header.Set(RequestIDHeader, reqID)
+		}
+		info := &accessInfo{}
+		ctx := context.WithValue(r.Context(), utils.RequestIDKey, reqID)
+		ctx = context.WithValue(ctx, ctxKeyAccessInfo{}, info)
+		r = r.WithContext(ctx)
+		w.Header().Set(RequestIDHeader, reqID)
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			fields := []zap.Field{
+				logkit.ZapRequestID(r),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("query", r.URL.RawQuery),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Int("status", sw.status()),
+				zap.Int64("bytes", sw.bytes),
+				zap.Duration("latency", time.Since(start)),
+				zap.String("eigenkey", info.eigenkey),
+				zap.String("rule", info.rule),
+				zap.Bool("matched", info.matched),
+			}
+			if v := recover(); v != nil {
+				// NOTE: mock.Handler通过http.ErrAbortHandler模拟连接中断，记录后继续抛出
+				logger.Warn("request aborted", append(fields, zap.Any("panic", v))...)
+				panic(v)
+			}
+			logger.Info("access", fields...)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter 记录状态码和写出的字节数
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
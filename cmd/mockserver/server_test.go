@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, rules, upstream string) (*server, *bytes.Buffer) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := ioutil.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	s, err := newServer(config{Rules: file, Upstream: upstream}, newLogger("", &buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &buf
+}
+
+func TestServer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+	s, buf := newTestServer(t, `
+- name: a
+  path: /a
+  mocker:
+    response_mocker: ResponseMockerBuilder
+    status_code: 201
+    body: mocked
+`, upstream.URL)
+
+	w := httptest.NewRecorder()
+	rq := httptest.NewRequest("GET", "http://localhost/a", nil)
+	rq.Header.Set(RequestIDHeader, "req-1")
+	s.handler.ServeHTTP(w, rq)
+	if w.Code != 201 || w.Body.String() != "mocked" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w.Header().Get(RequestIDHeader) != "req-1" {
+		t.Fatal("should echo request id")
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lastLine(buf), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["request_id"] != "req-1" || entry["rule"] != "a" || entry["matched"] != true || entry["status"] != float64(201) {
+		t.Fatal(entry)
+	}
+
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/b", nil))
+	if w.Code != 200 || w.Body.String() != "upstream" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w.Header().Get(RequestIDHeader) == "" {
+		t.Fatal("should generate request id")
+	}
+	entry = nil
+	if err := json.Unmarshal(lastLine(buf), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["request_id"] != w.Header().Get(RequestIDHeader) || entry["matched"] != false {
+		t.Fatal(entry)
+	}
+}
+
+func TestServerNoUpstream(t *testing.T) {
+	s, _ := newTestServer(t, `[]`, "")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/a", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestServerAdmin(t *testing.T) {
+	s, _ := newTestServer(t, `[]`, "")
+	body := `{"name": "b", "path": "/b", "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 202}}`
+	w := httptest.NewRecorder()
+	s.admin.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost/rules", strings.NewReader(body)))
+	if w.Code >= 300 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/b", nil))
+	if w.Code != 202 {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestServerBadRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	if err := ioutil.WriteFile(file, []byte(`[{"name": "a", "mocker": {"response_mocker": "NotExists"}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newServer(config{Rules: file}, newLogger("", ioutil.Discard))
+	if err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func lastLine(buf *bytes.Buffer) []byte {
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	return lines[len(lines)-1]
+}
+
+func TestServerSession(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	if err := ioutil.WriteFile(file, []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(config{Rules: file, Session: `{"header": "X-Mock-Session"}`}, newLogger("", ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.matcher.Session == nil || s.matcher.Session.Header != "X-Mock-Session" {
+		t.Fatal("should set session key")
+	}
+	_, err = newServer(config{Rules: file, Session: `{`}, newLogger("", ioutil.Discard))
+	if err == nil {
+		t.Fatal("should fail for invalid session")
+	}
+}
@@ -0,0 +1,140 @@
+package eigenkey
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Component 类型
+const (
+	KindRemoteAddr = "remote_addr"
+	KindClientIP   = "client_ip"
+	KindNamespace  = "namespace"
+	KindMethod     = "method"
+	KindURL        = "url"
+	KindProto      = "proto"
+	KindHeaders    = "headers"
+	KindBody       = "body"
+	KindCookies    = "cookies"
+	KindTLS        = "tls"
+	KindRaw        = "raw" // NOTE: 字符串特征键生成函数的结果，见AdaptHTTPRequestEigenkeyGen
+)
+
+// Component 特征键的组成部分
+type Component struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Eigenkey 结构化特征键，由有序的Component组成
+// 1. Encode为无歧义的长度前缀编码，ParseEigenkey可解析回Component，json序列化为Component数组，同样无歧义;
+// 2. String为各Component值以`:`连接，与DefaultHTTPEigenkeyFunc的结果一致，值中含`:`时有歧义，仅用于展示和兼容
+type Eigenkey []Component
+
+// Get 返回第一个指定类型的Component的值
+func (k Eigenkey) Get(kind string) (string, bool) {
+	for _, c := range k {
+		if c.Kind == kind {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+// String 各Component的值以`:`连接
+func (k Eigenkey) String() string {
+	values := make([]string, len(k))
+	for i, c := range k {
+		values[i] = c.Value
+	}
+	return strings.Join(values, ":")
+}
+
+// Encode 长度前缀编码，每个Component编码为`len(kind):kind len(value):value`(中间无空格)，如`6:method3:GET3:url2:/a`
+func (k Eigenkey) Encode() string {
+	var buf strings.Builder
+	for _, c := range k {
+		writeLengthPrefixed(&buf, c.Kind)
+		writeLengthPrefixed(&buf, c.Value)
+	}
+	return buf.String()
+}
+
+func writeLengthPrefixed(buf *strings.Builder, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+// ParseEigenkey 解析Encode的结果
+func ParseEigenkey(s string) (Eigenkey, error) {
+	var key Eigenkey
+	for len(s) > 0 {
+		var c Component
+		var err error
+		c.Kind, s, err = readLengthPrefixed(s)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse kind of component %d failed", len(key))
+		}
+		if s == "" {
+			return nil, errors.Errorf("component %d has no value", len(key))
+		}
+		c.Value, s, err = readLengthPrefixed(s)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse value of component %d failed", len(key))
+		}
+		key = append(key, c)
+	}
+	return key, nil
+}
+
+func readLengthPrefixed(s string) (string, string, error) {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return "", "", errors.New("missing length prefix")
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 || s[0] == '+' {
+		return "", "", errors.Errorf("invalid length %s", s[:i])
+	}
+	s = s[i+1:]
+	if n > len(s) {
+		return "", "", errors.Errorf("length %d exceeds remaining %d bytes", n, len(s))
+	}
+	return s[:n], s[n:], nil
+}
+
+// HTTPRequestEigenkeyBuilder 定义根据请求信息生成结构化特征键的函数
+type HTTPRequestEigenkeyBuilder func(namespace string, info *HTTPRequestInfo) Eigenkey
+
+// DefaultHTTPEigenkey 默认的结构化特征键，Component的顺序和取值与DefaultHTTPEigenkeyFunc一致，空值省略
+func DefaultHTTPEigenkey(ns string, info *HTTPRequestInfo) Eigenkey {
+	var key Eigenkey
+	add := func(kind, value string) {
+		if value != "" {
+			key = append(key, Component{Kind: kind, Value: value})
+		}
+	}
+	add(KindRemoteAddr, info.RemoteAddr)
+	add(KindClientIP, info.ClientIP)
+	add(KindNamespace, ns)
+	add(KindMethod, info.Method)
+	if u := info.URL(); u != nil {
+		add(KindURL, u.String())
+	}
+	add(KindProto, info.Proto)
+	add(KindHeaders, info.HeaderString())
+	add(KindBody, info.BodyString())
+	add(KindCookies, info.CookieString())
+	add(KindTLS, info.TLSString())
+	return key
+}
+
+// AdaptHTTPRequestEigenkeyGen 将字符串特征键生成函数适配为HTTPRequestEigenkeyBuilder，结果为单个KindRaw的Component
+func AdaptHTTPRequestEigenkeyGen(gen HTTPRequestEigenkeyGen, postFns ...KeyPostFunc) HTTPRequestEigenkeyBuilder {
+	return func(ns string, info *HTTPRequestInfo) Eigenkey {
+		return Eigenkey{{Kind: KindRaw, Value: gen(ns, info, postFns...)}}
+	}
+}
@@ -0,0 +1,141 @@
+package eigenkey_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ccmonky/typemap"
+
+	"github.com/ccmonky/pkg/eigenkey"
+)
+
+func TestEigenkeyEncode(t *testing.T) {
+	key := eigenkey.Eigenkey{
+		{Kind: eigenkey.KindMethod, Value: "GET"},
+		{Kind: eigenkey.KindURL, Value: "/a:b"},
+		{Kind: eigenkey.KindHeaders, Value: "x=1:2"},
+		{Kind: eigenkey.KindBody, Value: ""},
+	}
+	encoded := key.Encode()
+	if encoded != "6:method3:GET3:url4:/a:b7:headers5:x=1:24:body0:" {
+		t.Fatal(encoded)
+	}
+	parsed, err := eigenkey.ParseEigenkey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, key) {
+		t.Fatal(parsed)
+	}
+	if key.String() != "GET:/a:b:x=1:2:" {
+		t.Fatal(key.String())
+	}
+	if v, ok := key.Get(eigenkey.KindURL); !ok || v != "/a:b" {
+		t.Fatal(v)
+	}
+	if _, ok := key.Get(eigenkey.KindTLS); ok {
+		t.Fatal("should not exist")
+	}
+
+	// NOTE: String相同但结构不同的特征键，Encode和json不同
+	other := eigenkey.Eigenkey{
+		{Kind: eigenkey.KindMethod, Value: "GET:/a"},
+		{Kind: eigenkey.KindURL, Value: "b:x=1:2:"},
+	}
+	if other.String() != key.String() || other.Encode() == key.Encode() {
+		t.Fatal("encode should be unambiguous")
+	}
+	data, _ := json.Marshal(key)
+	var decoded eigenkey.Eigenkey
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, key) {
+		t.Fatal(string(data), err)
+	}
+
+	empty, err := eigenkey.ParseEigenkey("")
+	if err != nil || len(empty) != 0 {
+		t.Fatal(empty, err)
+	}
+	for _, bad := range []string{"6:method", "6:method3:GE", "x:method3:GET", ":", "-1:3:GET", "+6:method3:GET", "6method"} {
+		if _, err := eigenkey.ParseEigenkey(bad); err == nil {
+			t.Fatalf("%s should fail", bad)
+		}
+	}
+}
+
+func TestDefaultHTTPEigenkey(t *testing.T) {
+	info := &eigenkey.HTTPRequestInfo{
+		Method:       "POST",
+		Path:         "/a:b",
+		UseArguments: []string{"a"},
+		Arguments:    url.Values{"a": {"1"}},
+		UseHeaders:   []string{"X-Id"},
+		Headers:      url.Values{"X-Id": {"42"}},
+		ClientIP:     "1.1.1.1",
+	}
+	key := eigenkey.DefaultHTTPEigenkey("ns", info)
+	want := eigenkey.Eigenkey{
+		{Kind: eigenkey.KindClientIP, Value: "1.1.1.1"},
+		{Kind: eigenkey.KindNamespace, Value: "ns"},
+		{Kind: eigenkey.KindMethod, Value: "POST"},
+		{Kind: eigenkey.KindURL, Value: "/a:b?a=1"},
+		{Kind: eigenkey.KindHeaders, Value: "X-Id=42"},
+	}
+	if !reflect.DeepEqual(key, want) {
+		t.Fatal(key)
+	}
+	if key.String() != eigenkey.DefaultHTTPEigenkeyFunc("ns", info) {
+		t.Fatal("String should be compatible with DefaultHTTPEigenkeyFunc")
+	}
+}
+
+func TestHTTPRequestEigenkeyExtractorStructured(t *testing.T) {
+	rq, _ := http.NewRequest("GET", "http://localhost/a?x=1", nil)
+
+	extractor := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UseMethod: true, UsePath: true},
+		KeyPostFuncNames: []string{"md5"},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := extractor.StructuredEigenkey(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Encode() != "6:method3:GET3:url2:/a" {
+		t.Fatal(key.Encode())
+	}
+	s, _ := extractor.Eigenkey(rq)
+	if s != eigenkey.MD5(key.String()) {
+		t.Fatal(s)
+	}
+
+	// NOTE: 自定义的字符串特征键函数通过适配器继续使用
+	typemap.MustRegister[eigenkey.HTTPRequestEigenkeyGen](context.Background(), "test-structured-legacy",
+		func(ns string, info *eigenkey.HTTPRequestInfo, postFns ...eigenkey.KeyPostFunc) string {
+			return "legacy|" + info.Method + "|" + info.Path
+		})
+	extractor = eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UseMethod: true, UsePath: true},
+		KeyFuncName:      "test-structured-legacy",
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	key, err = extractor.StructuredEigenkey(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, eigenkey.Eigenkey{{Kind: eigenkey.KindRaw, Value: "legacy|GET|/a"}}) || key.String() != "legacy|GET|/a" {
+		t.Fatal(key)
+	}
+
+	extractor = eigenkey.HTTPRequestEigenkeyExtractor{KeyBuilderName: "not-exists"}
+	if err := extractor.Provision(); err == nil {
+		t.Fatal("should fail for unknown builder")
+	}
+}
@@ -1,7 +1,12 @@
 package eigenkey
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -9,39 +14,17 @@ import (
 	"github.com/ccmonky/typemap"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/ccmonky/pkg/utils"
 )
 
 // HTTPRequestEigenkeyGen 定义根据请求信息生成特征键的函数
 type HTTPRequestEigenkeyGen func(namespace string, info *HTTPRequestInfo, postFuncs ...KeyPostFunc) string
 
-// DefaultHTTPEigenkeyFunc 定义默认的HTTP请求特征提取键函数
+// DefaultHTTPEigenkeyFunc 定义默认的HTTP请求特征提取键函数，即DefaultHTTPEigenkey的String
 func DefaultHTTPEigenkeyFunc(ns string, info *HTTPRequestInfo, postFns ...KeyPostFunc) string {
-	var parts []string
-	if info.RemoteAddr != "" {
-		parts = append(parts, info.RemoteAddr)
-	}
-	if ns != "" {
-		parts = append(parts, ns)
-	}
-	if info.Method != "" {
-		parts = append(parts, info.Method)
-	}
-	var us string
-	u := info.URL()
-	if u != nil {
-		us = u.String()
-		if us != "" {
-			parts = append(parts, us)
-		}
-	}
-	if info.Proto != "" {
-		parts = append(parts, info.Proto)
-	}
-	hs := info.HeaderString()
-	if hs != "" {
-		parts = append(parts, hs)
-	}
-	key := strings.Join(parts, ":")
+	key := DefaultHTTPEigenkey(ns, info).String()
 	for _, fn := range postFns {
 		key = fn(key)
 	}
@@ -63,37 +46,75 @@ type HTTPRequestInfo struct {
 	UseHeaders   []string
 	Headers      url.Values
 	RemoteAddr   string
+	Route        string // NOTE: 匹配的路由模板，未配置或未匹配时为空
+
+	UseJSONPaths       []string
+	JSONValues         url.Values // NOTE: gjson path->值，不存在的path不包含
+	UseMultipartFields []string
+	MultipartFields    url.Values // NOTE: 字段名->值，文件字段为文件名
+	BodyHash           string     // NOTE: 整个Body的sha256
+
+	UseCookies        []string
+	Cookies           url.Values
+	ClientIP          string   // NOTE: 基于可信代理解析的真实客户端IP
+	ClientCertSubject string   // NOTE: 客户端证书的Subject
+	ClientCertSANs    []string // NOTE: 客户端证书的SAN
+	ServerName        string   // NOTE: TLS SNI
 }
 
 // QueryString 根据UseArguments和Arguments生成RawQuery
 func (i HTTPRequestInfo) QueryString() string {
-	if i.Arguments == nil {
-		return ""
-	}
-	var buf strings.Builder
-	for _, k := range i.UseArguments {
-		vs := i.Arguments[k]
-		keyEscaped := url.QueryEscape(k)
-		for _, v := range vs {
-			if buf.Len() > 0 {
-				buf.WriteByte('&')
-			}
-			buf.WriteString(keyEscaped)
-			buf.WriteByte('=')
-			buf.WriteString(url.QueryEscape(v))
-		}
-	}
-	return buf.String()
+	return encodeValues(i.UseArguments, i.Arguments)
 }
 
 // HeaderString 根据UseHeaders和Headers生成HeaderString
 func (i HTTPRequestInfo) HeaderString() string {
-	if i.Headers == nil {
+	return encodeValues(i.UseHeaders, i.Headers)
+}
+
+// BodyString 根据JSONValues、MultipartFields和BodyHash生成BodyString，格式同HeaderString，hash的key为`#sha256`
+func (i HTTPRequestInfo) BodyString() string {
+	var parts []string
+	if s := encodeValues(i.UseJSONPaths, i.JSONValues); s != "" {
+		parts = append(parts, s)
+	}
+	if s := encodeValues(i.UseMultipartFields, i.MultipartFields); s != "" {
+		parts = append(parts, s)
+	}
+	if i.BodyHash != "" {
+		parts = append(parts, url.QueryEscape("#sha256")+"="+i.BodyHash)
+	}
+	return strings.Join(parts, "&")
+}
+
+// CookieString 根据UseCookies和Cookies生成CookieString，格式同HeaderString
+func (i HTTPRequestInfo) CookieString() string {
+	return encodeValues(i.UseCookies, i.Cookies)
+}
+
+// TLSString 根据ServerName、ClientCertSubject和ClientCertSANs生成TLSString，格式同HeaderString，key分别为`#sni`、`#subject`和`#san`
+func (i HTTPRequestInfo) TLSString() string {
+	values := url.Values{}
+	if i.ServerName != "" {
+		values.Set("#sni", i.ServerName)
+	}
+	if i.ClientCertSubject != "" {
+		values.Set("#subject", i.ClientCertSubject)
+	}
+	if len(i.ClientCertSANs) > 0 {
+		values["#san"] = i.ClientCertSANs
+	}
+	return encodeValues([]string{"#sni", "#subject", "#san"}, values)
+}
+
+// encodeValues 按keys的顺序编码values，类似url.Values.Encode但不排序
+func encodeValues(keys []string, values url.Values) string {
+	if values == nil {
 		return ""
 	}
 	var buf strings.Builder
-	for _, k := range i.UseHeaders {
-		vs := i.Headers[k]
+	for _, k := range keys {
+		vs := values[k]
 		keyEscaped := url.QueryEscape(k)
 		for _, v := range vs {
 			if buf.Len() > 0 {
@@ -142,10 +163,71 @@ type HTTPRequestExtractor struct {
 	UseArguments  []string `json:"use_arguments"`
 	UseHeaders    []string `json:"use_headers"`
 	UseRemoteAddr bool     `json:"use_remote_addr"`
+
+	// NOTE: 以下特征需读取Body，读取后会回填r.Body
+	UseJSONPaths       []string `json:"use_json_paths"`       // NOTE: gjson path
+	UseMultipartFields []string `json:"use_multipart_fields"` // NOTE: multipart/form-data字段名，文件字段取文件名
+	UseBodyHash        bool     `json:"use_body_hash"`        // NOTE: 整个Body的sha256
+	MaxBodySize        int64    `json:"max_body_size"`        // NOTE: 读取Body的上限，为0时使用utils.DefaultMaxBodySize，小于0时不限制
+
+	UseCookies           []string `json:"use_cookies"`
+	UseClientIP          bool     `json:"use_client_ip"`           // NOTE: 基于TrustedProxies从X-Forwarded-For/X-Real-IP解析真实客户端IP，不含端口
+	TrustedProxies       []string `json:"trusted_proxies"`         // NOTE: 可信代理的CIDR或IP
+	UseClientCertSubject bool     `json:"use_client_cert_subject"` // NOTE: 客户端证书的Subject
+	UseClientCertSAN     bool     `json:"use_client_cert_san"`     // NOTE: 客户端证书的SAN
+	ClientCertHeader     string   `json:"client_cert_header"`      // NOTE: TLS在代理终止时，代理转发的PEM格式客户端证书头，如X-Client-Cert，仅信任TrustedProxies转发的证书头
+	UseSNI               bool     `json:"use_sni"`
+
+	trustedProxies []*net.IPNet
+}
+
+// Provision 解析可信代理，未调用时在每次Extract时解析
+func (e *HTTPRequestExtractor) Provision() error {
+	var err error
+	e.trustedProxies, err = ParseTrustedProxies(e.TrustedProxies)
+	return err
+}
+
+// NeedBody 判断Extract是否会读取r.Body，调用方可据此决定是否需要先缓存Body
+// NOTE: 使用json path、multipart字段或body hash时读取Body，表单请求的ParseForm也会消耗Body
+func (e HTTPRequestExtractor) NeedBody(r *http.Request) bool {
+	return e.useBody() || parseFormReadsBody(r)
+}
+
+func (e HTTPRequestExtractor) useBody() bool {
+	return len(e.UseJSONPaths) > 0 || len(e.UseMultipartFields) > 0 || e.UseBodyHash
+}
+
+// parseFormReadsBody 判断r.ParseForm是否会读取Body，即未解析过的POST、PUT、PATCH表单请求
+func parseFormReadsBody(r *http.Request) bool {
+	if r.PostForm != nil || r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
 }
 
 // Extract 抽取HTTP特征
 func (e HTTPRequestExtractor) Extract(r *http.Request) (*HTTPRequestInfo, error) {
+	var body []byte
+	useBody := e.useBody()
+	if useBody {
+		var err error
+		limit := e.MaxBodySize
+		if limit == 0 {
+			limit = utils.DefaultMaxBodySize
+		}
+		body, err = utils.ReadAndRestoreBodyLimit(r, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer utils.RestoreBody(r, body) // NOTE: ParseForm会消耗application/x-www-form-urlencoded的Body
+	}
 	err := r.ParseForm()
 	if err != nil {
 		return nil, err
@@ -202,19 +284,159 @@ func (e HTTPRequestExtractor) Extract(r *http.Request) (*HTTPRequestInfo, error)
 	if e.UseRemoteAddr {
 		info.RemoteAddr = r.RemoteAddr
 	}
+	if useBody {
+		err = e.extractBody(r, body, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = e.extractIdentity(r, info)
+	if err != nil {
+		return nil, err
+	}
 	return info, nil
 }
 
+func (e HTTPRequestExtractor) extractIdentity(r *http.Request, info *HTTPRequestInfo) error {
+	if len(e.UseCookies) > 0 {
+		info.UseCookies = e.UseCookies
+		info.Cookies = make(url.Values)
+		for _, name := range e.UseCookies {
+			if c, err := r.Cookie(name); err == nil {
+				info.Cookies.Set(name, c.Value)
+			}
+		}
+	}
+	if e.UseClientIP {
+		trustedProxies, err := e.trusted()
+		if err != nil {
+			return err
+		}
+		info.ClientIP = ClientIP(r, trustedProxies)
+	}
+	if e.UseClientCertSubject || e.UseClientCertSAN {
+		trustedProxies, err := e.trusted()
+		if err != nil {
+			return err
+		}
+		cert, err := ClientCertificate(r, e.ClientCertHeader, trustedProxies)
+		if err != nil {
+			return err
+		}
+		if cert != nil {
+			if e.UseClientCertSubject {
+				info.ClientCertSubject = cert.Subject.String()
+			}
+			if e.UseClientCertSAN {
+				info.ClientCertSANs = CertificateSANs(cert)
+			}
+		}
+	}
+	if e.UseSNI && r.TLS != nil {
+		info.ServerName = r.TLS.ServerName
+	}
+	return nil
+}
+
+func (e HTTPRequestExtractor) trusted() ([]*net.IPNet, error) {
+	if e.trustedProxies != nil || len(e.TrustedProxies) == 0 {
+		return e.trustedProxies, nil
+	}
+	return ParseTrustedProxies(e.TrustedProxies)
+}
+
+func (e HTTPRequestExtractor) extractBody(r *http.Request, body []byte, info *HTTPRequestInfo) error {
+	if len(e.UseJSONPaths) > 0 {
+		info.UseJSONPaths = e.UseJSONPaths
+		info.JSONValues = make(url.Values)
+		if gjson.ValidBytes(body) {
+			for _, path := range e.UseJSONPaths {
+				result := gjson.GetBytes(body, path)
+				if result.Exists() {
+					info.JSONValues[path] = []string{result.String()}
+				}
+			}
+		}
+	}
+	if len(e.UseMultipartFields) > 0 {
+		info.UseMultipartFields = e.UseMultipartFields
+		fields, err := multipartFields(r, body, e.UseMultipartFields)
+		if err != nil {
+			return err
+		}
+		info.MultipartFields = fields
+	}
+	if e.UseBodyHash {
+		info.BodyHash = SHA256(string(body))
+	}
+	return nil
+}
+
+// multipartFields 从Body中解析multipart字段，不调用r.ParseMultipartForm，避免消耗Body和写临时文件
+func multipartFields(r *http.Request, body []byte, names []string) (url.Values, error) {
+	fields := make(url.Values)
+	if r.MultipartForm != nil {
+		// NOTE: 已解析过时Body已被消耗，直接使用解析结果
+		for _, name := range names {
+			fields[name] = append(fields[name], r.MultipartForm.Value[name]...)
+			for _, fh := range r.MultipartForm.File[name] {
+				fields[name] = append(fields[name], fh.Filename)
+			}
+		}
+		return fields, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "multipart/form-data" && mediaType != "multipart/mixed") || params["boundary"] == "" {
+		return fields, nil
+	}
+	selected := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		selected[name] = struct{}{}
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "read multipart failed")
+		}
+		name := part.FormName()
+		if _, ok := selected[name]; !ok {
+			continue
+		}
+		if filename := part.FileName(); filename != "" {
+			fields[name] = append(fields[name], filename)
+			continue
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read multipart field %s failed", name)
+		}
+		fields[name] = append(fields[name], string(value))
+	}
+}
+
+// ErrNotProvisioned HTTPRequestEigenkeyExtractor未调用Provision
+var ErrNotProvisioned = errors.New("http request eigenkey extractor is not provisioned")
+
 // HTTPRequestEigenkeyExtractor http请求特征提取器
 type HTTPRequestEigenkeyExtractor struct {
 	Namespace        string                `json:"namespace"`
 	KeyFuncName      string                `json:"key_func_name"`
 	KeyPostFuncNames []string              `json:"key_post_func_names"`
+	KeyBuilderName   string                `json:"key_builder_name"` // NOTE: StructuredEigenkey使用的HTTPRequestEigenkeyBuilder，为空且KeyFuncName不是默认函数时适配KeyFuncName
 	RequestExtractor *HTTPRequestExtractor `json:"request_extractor"`
 	CleanPath        bool                  `json:"clean_path"`
+	Routes           []*Route              `json:"routes"`           // NOTE: 按httprouter规则匹配路径，匹配时使用模板代替原始路径，在CleanPath之后执行
+	NormalizerNames  []string              `json:"normalizer_names"` // NOTE: 按顺序在Routes之后、生成特征键之前执行
 
-	keyFn      HTTPRequestEigenkeyGen
-	keyPostFns []KeyPostFunc
+	keyFn       HTTPRequestEigenkeyGen
+	keyBuilder  HTTPRequestEigenkeyBuilder
+	keyPostFns  []KeyPostFunc
+	normalizers []HTTPRequestNormalizer
+	routes      *routeTable
 }
 
 // Provision 初始化
@@ -227,6 +449,19 @@ func (g *HTTPRequestEigenkeyExtractor) Provision() error {
 	if g.keyFn == nil {
 		return errors.Errorf("http request eigenkey func %s is nil", g.KeyFuncName)
 	}
+	if g.KeyBuilderName == "" && g.KeyFuncName != "" && g.KeyFuncName != "default" {
+		g.keyBuilder = AdaptHTTPRequestEigenkeyGen(g.keyFn)
+	} else {
+		g.keyBuilder, err = typemap.Get[HTTPRequestEigenkeyBuilder](context.Background(), g.KeyBuilderName)
+		if err != nil {
+			return err
+		}
+		if g.keyBuilder == nil {
+			return errors.Errorf("http request eigenkey builder %s is nil", g.KeyBuilderName)
+		}
+	}
+	// NOTE: 重复调用Provision时重新构建，避免重复执行后处理和归一化函数
+	g.keyPostFns, g.normalizers = nil, nil
 	for _, postName := range g.KeyPostFuncNames {
 		fn, err := typemap.Get[KeyPostFunc](context.Background(), postName)
 		if err != nil {
@@ -236,22 +471,75 @@ func (g *HTTPRequestEigenkeyExtractor) Provision() error {
 			g.keyPostFns = append(g.keyPostFns, fn)
 		}
 	}
+	for _, name := range g.NormalizerNames {
+		fn, err := typemap.Get[HTTPRequestNormalizer](context.Background(), name)
+		if err != nil {
+			return err
+		}
+		if fn != nil {
+			g.normalizers = append(g.normalizers, fn)
+		}
+	}
+	if len(g.Routes) > 0 {
+		g.routes, err = newRouteTable(g.Routes)
+		if err != nil {
+			return err
+		}
+	}
 	if g.RequestExtractor == nil {
 		g.RequestExtractor = &HTTPRequestExtractor{
 			UsePath: true,
 		}
 	}
-	return nil
+	return g.RequestExtractor.Provision()
+}
+
+// NeedBody 判断提取特征时是否会读取r.Body，见HTTPRequestExtractor.NeedBody
+func (g HTTPRequestEigenkeyExtractor) NeedBody(r *http.Request) bool {
+	if g.RequestExtractor == nil {
+		return parseFormReadsBody(r)
+	}
+	return g.RequestExtractor.NeedBody(r)
 }
 
 // Eigenkey 从给定的请求中提取Eigenkey
 func (g HTTPRequestEigenkeyExtractor) Eigenkey(r *http.Request) (string, error) {
-	info, err := g.RequestExtractor.Extract(r)
+	info, err := g.Info(r)
 	if err != nil {
 		return "", err
 	}
+	return g.keyFn(g.Namespace, info, g.keyPostFns...), nil
+}
+
+// StructuredEigenkey 从给定的请求中提取结构化特征键
+// NOTE: 不执行KeyPostFuncNames，hash等后处理会破坏结构，需要时对Encode的结果处理
+func (g HTTPRequestEigenkeyExtractor) StructuredEigenkey(r *http.Request) (Eigenkey, error) {
+	info, err := g.Info(r)
+	if err != nil {
+		return nil, err
+	}
+	return g.keyBuilder(g.Namespace, info), nil
+}
+
+// Info 抽取请求信息，并依次执行CleanPath、Routes和NormalizerNames
+func (g HTTPRequestEigenkeyExtractor) Info(r *http.Request) (*HTTPRequestInfo, error) {
+	if g.keyFn == nil || g.RequestExtractor == nil {
+		return nil, ErrNotProvisioned
+	}
+	info, err := g.RequestExtractor.Extract(r)
+	if err != nil {
+		return nil, err
+	}
 	if g.CleanPath && g.RequestExtractor.UsePath {
 		info.Path = httprouter.CleanPath(info.Path)
 	}
-	return g.keyFn(g.Namespace, info, g.keyPostFns...), nil
+	if g.routes != nil && g.RequestExtractor.UsePath {
+		if route, ps := g.routes.Match(info.Path); route != nil {
+			info.Path, info.Route = route.Path(ps), route.Pattern
+		}
+	}
+	for _, fn := range g.normalizers {
+		fn(info)
+	}
+	return info, nil
 }
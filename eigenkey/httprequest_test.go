@@ -2,11 +2,18 @@ package eigenkey_test
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/utils"
 )
 
 func TestURLValues(t *testing.T) {
@@ -269,7 +276,7 @@ func TestHTTPRequestEigenkeyExtractor(t *testing.T) {
 	}
 
 	rq, err := http.NewRequest("POST", "http://localhost/?a=1&b=2", bytes.NewReader([]byte(``)))
-	rq.Form = url.Values{"posta": []string{"1"}, "postb":[]string{"2"}}
+	rq.Form = url.Values{"posta": []string{"1"}, "postb": []string{"2"}}
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -308,3 +315,148 @@ func TestHTTPRequestEigenkeyExtractor(t *testing.T) {
 		t.Fatal(ek)
 	}
 }
+
+func TestHTTPRequestExtractorJSONPaths(t *testing.T) {
+	extractor := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{
+			UsePath:      true,
+			UseJSONPaths: []string{"user.id", "items.#", "missing"},
+		},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"user": {"id": 42, "name": "a"}, "items": [1, 2, 3]}`
+	rq, _ := http.NewRequest("POST", "http://localhost/orders", strings.NewReader(body))
+	rq.Header.Set("Content-Type", "application/json")
+	key, err := extractor.Eigenkey(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "/orders:user.id=42&items.%23=3" {
+		t.Fatal(key)
+	}
+	restored, _ := ioutil.ReadAll(rq.Body)
+	if string(restored) != body {
+		t.Fatal("body should be restored")
+	}
+
+	rq, _ = http.NewRequest("POST", "http://localhost/orders", strings.NewReader(`not json`))
+	key, err = extractor.Eigenkey(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "/orders" {
+		t.Fatal(key)
+	}
+}
+
+func TestHTTPRequestExtractorBodyHash(t *testing.T) {
+	extractor := eigenkey.HTTPRequestExtractor{
+		UsePath:      true,
+		UseArguments: []string{"a"},
+		UseBodyHash:  true,
+	}
+	body := "a=1&b=2"
+	rq, _ := http.NewRequest("POST", "http://localhost/form", strings.NewReader(body))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	info, err := extractor.Extract(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Arguments.Get("a") != "1" || info.BodyHash != eigenkey.SHA256(body) {
+		t.Fatal(info)
+	}
+	restored, _ := ioutil.ReadAll(rq.Body)
+	if string(restored) != body {
+		t.Fatal("body consumed by ParseForm should be restored")
+	}
+	if info.BodyString() != "%23sha256="+eigenkey.SHA256(body) {
+		t.Fatal(info.BodyString())
+	}
+	extractor.MaxBodySize = 4
+	rq, _ = http.NewRequest("POST", "http://localhost/form", strings.NewReader(body))
+	if _, err = extractor.Extract(rq); !errors.Is(err, utils.ErrBodyTooLarge) {
+		t.Fatal("should fail for body exceeding max_body_size", err)
+	}
+	if restored, _ = ioutil.ReadAll(rq.Body); string(restored) != body {
+		t.Fatal("body exceeding max_body_size should be restored")
+	}
+}
+
+func TestHTTPRequestExtractorMultipartFields(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("name", "alice")
+	mw.WriteField("ignored", "x")
+	fw, _ := mw.CreateFormFile("avatar", "me.png")
+	fw.Write([]byte("png"))
+	mw.Close()
+	body := buf.String()
+
+	extractor := eigenkey.HTTPRequestExtractor{UseMultipartFields: []string{"avatar", "name"}}
+	newRequest := func() *http.Request {
+		rq, _ := http.NewRequest("POST", "http://localhost/upload", strings.NewReader(body))
+		rq.Header.Set("Content-Type", mw.FormDataContentType())
+		return rq
+	}
+
+	rq := newRequest()
+	info, err := extractor.Extract(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BodyString() != "avatar=me.png&name=alice" {
+		t.Fatal(info.BodyString())
+	}
+	if err := rq.ParseMultipartForm(1 << 20); err != nil || rq.FormValue("name") != "alice" {
+		t.Fatal("body should be restored for downstream", err)
+	}
+
+	// NOTE: 已解析过multipart时使用解析结果
+	info, err = extractor.Extract(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BodyString() != "avatar=me.png&name=alice" {
+		t.Fatal(info.BodyString())
+	}
+}
+
+func TestHTTPRequestExtractorIdentity(t *testing.T) {
+	cert, _ := newClientCert(t)
+	extractor := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{
+			UsePath:              true,
+			UseCookies:           []string{"session", "missing"},
+			UseClientIP:          true,
+			TrustedProxies:       []string{"10.0.0.0/8"},
+			UseClientCertSubject: true,
+			UseClientCertSAN:     true,
+			UseSNI:               true,
+		},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	rq, _ := http.NewRequest("GET", "https://localhost/a", nil)
+	rq.RemoteAddr = "10.0.0.2:443"
+	rq.Header.Set("X-Forwarded-For", "1.1.1.1")
+	rq.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	rq.TLS = &tls.ConnectionState{ServerName: "api.acme.com", PeerCertificates: []*x509.Certificate{cert}}
+	key, err := extractor.Eigenkey(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1.1.1.1:/a:session=s1:%23sni=api.acme.com&%23subject=CN%3Dsvc-a%2CO%3Dacme&%23san=svc-a.internal&%23san=a%40acme.com&%23san=10.0.0.1"
+	if key != want {
+		t.Fatal(key)
+	}
+
+	bad := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UseClientIP: true, TrustedProxies: []string{"bad"}},
+	}
+	if err := bad.Provision(); err == nil {
+		t.Fatal("should fail for invalid trusted proxies")
+	}
+}
@@ -0,0 +1,131 @@
+package eigenkey
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// ParseTrustedProxies 解析可信代理列表，元素为CIDR或IP
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy %s", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid trusted proxy %s", proxy)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// ClientIP 解析请求的真实客户端IP
+// 1. RemoteAddr(去掉端口)不在可信代理中时直接使用;
+// 2. 否则从右向左遍历X-Forwarded-For，跳过可信代理，返回第一个不可信的IP，均可信时返回最左侧的IP;
+// 3. 没有X-Forwarded-For时使用X-Real-IP，仍没有时使用RemoteAddr
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote := remoteHost(r)
+	if !isTrusted(remote, trustedProxies) {
+		return remote
+	}
+	var forwarded []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !isTrusted(forwarded[i], trustedProxies) {
+			return forwarded[i]
+		}
+	}
+	if len(forwarded) > 0 {
+		return forwarded[0]
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
+
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func isTrusted(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCertificate 获取客户端证书，优先使用TLS连接的证书，其次使用代理转发的PEM格式证书头(允许url编码)，均没有时返回nil
+// NOTE: 与ClientIP相同，仅RemoteAddr在可信代理中时才读取证书头，否则客户端可以直接伪造证书头
+func ClientCertificate(r *http.Request, header string, trustedProxies []*net.IPNet) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], nil
+	}
+	if header == "" || !isTrusted(remoteHost(r), trustedProxies) {
+		return nil, nil
+	}
+	data := r.Header.Get(header)
+	if data == "" {
+		return nil, nil
+	}
+	if strings.Contains(data, "%") {
+		unescaped, err := url.PathUnescape(data)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "unescape client certificate header %s failed", header)
+		}
+		// NOTE: base64不含空格，`+`可能来自base64，只还原query编码的BEGIN/END行中的空格
+		data = strings.ReplaceAll(unescaped, "+CERTIFICATE-----", " CERTIFICATE-----")
+	}
+	cert, err := utils.ParseCertificateFromPEM([]byte(data))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "parse client certificate header %s failed", header)
+	}
+	return cert, nil
+}
+
+// CertificateSANs 返回证书的SAN，依次为DNS、Email、IP和URI
+func CertificateSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
@@ -0,0 +1,142 @@
+package eigenkey_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/pkg/eigenkey"
+)
+
+func newClientCert(t *testing.T) (*x509.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "svc-a", Organization: []string{"acme"}},
+		DNSNames:       []string{"svc-a.internal"},
+		EmailAddresses: []string{"a@acme.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := eigenkey.ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remote string
+		xff    []string
+		xri    string
+		want   string
+	}{
+		{"1.2.3.4:5678", []string{"9.9.9.9"}, "", "1.2.3.4"},
+		{"10.0.0.2:80", []string{"9.9.9.9, 1.1.1.1, 10.0.0.3"}, "", "1.1.1.1"},
+		{"10.0.0.2:80", []string{"9.9.9.9", "1.1.1.1"}, "", "1.1.1.1"},
+		{"192.168.1.1:80", []string{"10.0.0.4, 10.0.0.3"}, "", "10.0.0.4"},
+		{"[::1]:80", nil, "8.8.8.8", "8.8.8.8"},
+		{"10.0.0.2:80", nil, "invalid", "10.0.0.2"},
+	}
+	for _, tc := range cases {
+		rq, _ := http.NewRequest("GET", "http://localhost/", nil)
+		rq.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			rq.Header.Add("X-Forwarded-For", v)
+		}
+		if tc.xri != "" {
+			rq.Header.Set("X-Real-IP", tc.xri)
+		}
+		if got := eigenkey.ClientIP(rq, trusted); got != tc.want {
+			t.Fatalf("%s %v should be %s, got %s", tc.remote, tc.xff, tc.want, got)
+		}
+	}
+	if _, err := eigenkey.ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("should fail for invalid cidr")
+	}
+	if _, err := eigenkey.ParseTrustedProxies([]string{"proxy"}); err == nil {
+		t.Fatal("should fail for invalid ip")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert, data := newClientCert(t)
+	trusted, _ := eigenkey.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	rq, _ := http.NewRequest("GET", "http://localhost/", nil)
+	rq.RemoteAddr = "10.0.0.1:1234"
+	got, err := eigenkey.ClientCertificate(rq, "X-Client-Cert", trusted)
+	if err != nil || got != nil {
+		t.Fatal("should be nil without certificate", err)
+	}
+
+	rq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	got, err = eigenkey.ClientCertificate(rq, "", nil)
+	if err != nil || got != cert {
+		t.Fatal("should use tls peer certificate", err)
+	}
+
+	rq.TLS = nil
+	for _, v := range []string{url.QueryEscape(data), url.PathEscape(data)} {
+		rq.Header.Set("X-Client-Cert", v)
+		got, err = eigenkey.ClientCertificate(rq, "X-Client-Cert", trusted)
+		if err != nil || got.Subject.CommonName != "svc-a" {
+			t.Fatal("should parse escaped pem header", err)
+		}
+	}
+
+	// NOTE: 不可信的客户端直连时伪造的证书头应被忽略
+	forged, _ := http.NewRequest("GET", "http://localhost/", nil)
+	forged.RemoteAddr = "8.8.8.8:1234"
+	forged.Header.Set("X-Client-Cert", url.QueryEscape(data))
+	got, err = eigenkey.ClientCertificate(forged, "X-Client-Cert", trusted)
+	if err != nil || got != nil {
+		t.Fatal("should ignore header from untrusted remote", err)
+	}
+	extractor := &eigenkey.HTTPRequestExtractor{
+		UseClientCertSubject: true,
+		ClientCertHeader:     "X-Client-Cert",
+		TrustedProxies:       []string{"10.0.0.0/8"},
+	}
+	info, err := extractor.Extract(forged)
+	if err != nil || info.ClientCertSubject != "" {
+		t.Fatal("extractor should ignore forged header", err, info.ClientCertSubject)
+	}
+	forged.RemoteAddr = "10.0.0.1:1234"
+	info, err = extractor.Extract(forged)
+	if err != nil || info.ClientCertSubject == "" {
+		t.Fatal("extractor should use header from trusted proxy", err)
+	}
+
+	rq.Header.Set("X-Client-Cert", "not a certificate")
+	if _, err = eigenkey.ClientCertificate(rq, "X-Client-Cert", trusted); err == nil {
+		t.Fatal("should fail for invalid certificate")
+	}
+
+	sans := eigenkey.CertificateSANs(cert)
+	if len(sans) != 3 || sans[0] != "svc-a.internal" || sans[1] != "a@acme.com" || sans[2] != "10.0.0.1" {
+		t.Fatal(sans)
+	}
+}
@@ -1,12 +1,31 @@
 package eigenkey
 
-import "github.com/ccmonky/typemap"
+import (
+	"context"
+
+	"github.com/ccmonky/typemap"
+)
 
 func init() {
 	typemap.MustRegisterType[KeyPostFunc]()
 	typemap.MustRegisterType[HTTPRequestEigenkeyGen]()
+	typemap.MustRegisterType[HTTPRequestNormalizer]()
+	typemap.MustRegisterType[HTTPRequestEigenkeyBuilder]()
 	typemap.MustRegisterType[*HTTPRequestEigenkeyExtractor](typemap.WithDependencies([]string{
 		typemap.GetTypeIdString[KeyPostFunc](),
 		typemap.GetTypeIdString[HTTPRequestEigenkeyGen](),
+		typemap.GetTypeIdString[HTTPRequestNormalizer](),
+		typemap.GetTypeIdString[HTTPRequestEigenkeyBuilder](),
 	}))
+
+	typemap.MustRegister[HTTPRequestEigenkeyGen](context.Background(), "", DefaultHTTPEigenkeyFunc)
+	typemap.MustRegister[HTTPRequestEigenkeyGen](context.Background(), "default", DefaultHTTPEigenkeyFunc)
+	typemap.MustRegister[HTTPRequestEigenkeyBuilder](context.Background(), "", DefaultHTTPEigenkey)
+	typemap.MustRegister[HTTPRequestEigenkeyBuilder](context.Background(), "default", DefaultHTTPEigenkey)
+	for name, fn := range keyPostFuncRegistry {
+		typemap.MustRegister[KeyPostFunc](context.Background(), name, fn)
+	}
+	for name, fn := range normalizerRegistry {
+		typemap.MustRegister[HTTPRequestNormalizer](context.Background(), name, fn)
+	}
 }
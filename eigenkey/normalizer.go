@@ -0,0 +1,128 @@
+package eigenkey
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	normalizerRegistry = map[string]HTTPRequestNormalizer{
+		"lower_host":          LowerHost,
+		"strip_default_port":  StripDefaultPort,
+		"sort_arguments":      SortArguments,
+		"collapse_ids":        CollapseIDs,
+		"trim_trailing_slash": TrimTrailingSlash,
+		"decode_percent":      DecodePercent,
+	}
+
+	// IDPlaceholder CollapseIDs替换ID路径段使用的占位符
+	IDPlaceholder = ":id"
+
+	uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// HTTPRequestNormalizer 在生成特征键前规范化请求信息，使语义相同的请求得到相同的特征键
+// NOTE: info的切片和map字段可能与请求或配置共享，修改前需复制
+type HTTPRequestNormalizer func(info *HTTPRequestInfo)
+
+// LowerHost host转为小写
+func LowerHost(info *HTTPRequestInfo) {
+	info.Host = strings.ToLower(info.Host)
+}
+
+// StripDefaultPort 去掉host中的默认端口，http为80，https为443，scheme为空时两者均去掉
+func StripDefaultPort(info *HTTPRequestInfo) {
+	host, port, err := net.SplitHostPort(info.Host)
+	if err != nil {
+		return
+	}
+	var isDefault bool
+	switch strings.ToLower(info.Scheme) {
+	case "http", "ws":
+		isDefault = port == "80"
+	case "https", "wss":
+		isDefault = port == "443"
+	case "":
+		isDefault = port == "80" || port == "443"
+	}
+	if !isDefault {
+		return
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // NOTE: IPv6
+	}
+	info.Host = host
+}
+
+// SortArguments 按参数名排序参数，同名参数的值保持原有顺序
+func SortArguments(info *HTTPRequestInfo) {
+	args := make([]string, len(info.UseArguments))
+	copy(args, info.UseArguments)
+	sort.Strings(args)
+	info.UseArguments = args
+}
+
+// CollapseIDs 将纯数字或UUID的路径段替换为IDPlaceholder，如`/users/123/orders`->`/users/:id/orders`
+func CollapseIDs(info *HTTPRequestInfo) {
+	segments := strings.Split(info.Path, "/")
+	for i, seg := range segments {
+		if isNumeric(seg) || uuidRegexp.MatchString(seg) {
+			segments[i] = IDPlaceholder
+		}
+	}
+	info.Path = strings.Join(segments, "/")
+}
+
+// TrimTrailingSlash 去掉路径末尾的`/`，根路径保持`/`
+func TrimTrailingSlash(info *HTTPRequestInfo) {
+	if len(info.Path) <= 1 {
+		return
+	}
+	path := strings.TrimRight(info.Path, "/")
+	if path == "" {
+		path = "/"
+	}
+	info.Path = path
+}
+
+// DecodePercent 解码路径和参数值中残留的百分号编码
+// NOTE: net/url已解码过一次，此处用于处理客户端重复编码的情况，如`%2520`，解码失败时保持原值;
+// 参数值中的`+`是已解码的字面值，不再视为空格，否则`q=a%2Bb`与`q=a+b`会得到相同的特征键
+func DecodePercent(info *HTTPRequestInfo) {
+	if path, err := url.PathUnescape(info.Path); err == nil {
+		info.Path = path
+	}
+	if info.Arguments == nil {
+		return
+	}
+	args := make(url.Values, len(info.Arguments))
+	for k, vs := range info.Arguments {
+		decoded := make([]string, len(vs))
+		for i, v := range vs {
+			decoded[i] = v
+			if !strings.Contains(v, "%") {
+				continue
+			}
+			if d, err := url.PathUnescape(v); err == nil {
+				decoded[i] = d
+			}
+		}
+		args[k] = decoded
+	}
+	info.Arguments = args
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package eigenkey_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+)
+
+func TestNormalizers(t *testing.T) {
+	cases := []struct {
+		fn   eigenkey.HTTPRequestNormalizer
+		in   eigenkey.HTTPRequestInfo
+		want string
+	}{
+		{eigenkey.LowerHost, eigenkey.HTTPRequestInfo{Host: "API.Example.COM", Path: "/A"}, "//api.example.com/A"},
+		{eigenkey.StripDefaultPort, eigenkey.HTTPRequestInfo{Scheme: "http", Host: "a.com:80"}, "http://a.com"},
+		{eigenkey.StripDefaultPort, eigenkey.HTTPRequestInfo{Scheme: "https", Host: "a.com:443"}, "https://a.com"},
+		{eigenkey.StripDefaultPort, eigenkey.HTTPRequestInfo{Scheme: "http", Host: "a.com:443"}, "http://a.com:443"},
+		{eigenkey.StripDefaultPort, eigenkey.HTTPRequestInfo{Host: "[::1]:80"}, "//[::1]"},
+		{eigenkey.StripDefaultPort, eigenkey.HTTPRequestInfo{Host: "a.com:8080"}, "//a.com:8080"},
+		{eigenkey.CollapseIDs, eigenkey.HTTPRequestInfo{Path: "/users/123/orders/9b2f6c2e-1c5d-4a8e-9f0a-3b6d2c1e4f5a/items"}, "/users/:id/orders/:id/items"},
+		{eigenkey.CollapseIDs, eigenkey.HTTPRequestInfo{Path: "/v2/users/abc123"}, "/v2/users/abc123"},
+		{eigenkey.TrimTrailingSlash, eigenkey.HTTPRequestInfo{Path: "/users//"}, "/users"},
+		{eigenkey.TrimTrailingSlash, eigenkey.HTTPRequestInfo{Path: "/"}, "/"},
+		{eigenkey.DecodePercent, eigenkey.HTTPRequestInfo{Path: "/a%20b"}, "/a%20b"},
+		{eigenkey.DecodePercent, eigenkey.HTTPRequestInfo{Path: "/a%zzb"}, "/a%25zzb"},
+	}
+	for i, tc := range cases {
+		info := tc.in
+		tc.fn(&info)
+		if got := info.URL().String(); got != tc.want {
+			t.Fatalf("case %d: should be %s, got %s", i, tc.want, got)
+		}
+	}
+
+	useArgs := []string{"b", "a"}
+	info := &eigenkey.HTTPRequestInfo{
+		UseArguments: useArgs,
+		Arguments:    url.Values{"a": {"2", "1"}, "b": {"x%2520y"}},
+	}
+	eigenkey.SortArguments(info)
+	if info.QueryString() != "a=2&a=1&b=x%252520y" || useArgs[0] != "b" {
+		t.Fatal(info.QueryString(), useArgs)
+	}
+	eigenkey.DecodePercent(info)
+	if info.Arguments.Get("b") != "x%20y" {
+		t.Fatal(info.Arguments)
+	}
+	// NOTE: `q=a%2Bb`解码后为`a+b`，`q=a+b`解码后为`a b`，两者不应相同
+	plus := &eigenkey.HTTPRequestInfo{Arguments: url.Values{"q": {"a+b"}, "r": {"a+b%2520c"}}}
+	eigenkey.DecodePercent(plus)
+	if plus.Arguments.Get("q") != "a+b" || plus.Arguments.Get("r") != "a+b%20c" {
+		t.Fatal(plus.Arguments)
+	}
+	extractor := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UseArguments: []string{"q"}},
+		NormalizerNames:  []string{"decode_percent"},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, q := range []string{"a%2Bb", "a+b"} {
+		rq, _ := http.NewRequest("GET", "http://localhost/?q="+q, nil)
+		key, err := extractor.Eigenkey(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if keys[0] == keys[1] {
+		t.Fatal("literal + and space should not collide", keys)
+	}
+}
+
+func TestHTTPRequestEigenkeyExtractorNormalizers(t *testing.T) {
+	extractor := eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{
+			UseScheme:    true,
+			UseHost:      true,
+			UsePath:      true,
+			UseArguments: []string{"z", "a"},
+		},
+		NormalizerNames: []string{
+			"lower_host",
+			"strip_default_port",
+			"sort_arguments",
+			"collapse_ids",
+			"trim_trailing_slash",
+			"decode_percent",
+		},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, u := range []string{
+		"https://API.example.com:443/users/42/?z=1&a=2",
+		"https://api.example.com/users/7?a=2&z=1",
+	} {
+		rq, _ := http.NewRequest("GET", u, nil)
+		key, err := extractor.Eigenkey(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if keys[0] != "https://api.example.com/users/:id?a=2&z=1" || keys[0] != keys[1] {
+		t.Fatal(keys)
+	}
+
+	extractor = eigenkey.HTTPRequestEigenkeyExtractor{NormalizerNames: []string{"not_exists"}}
+	if err := extractor.Provision(); err == nil {
+		t.Fatal("should fail for unknown normalizer")
+	}
+}
@@ -0,0 +1,119 @@
+package eigenkey
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// Route 路由模板，语法同httprouter，如`/users/:id`、`/files/*path`
+// NOTE: 配置中可以直接使用字符串，等价于不使用任何路径参数的Route
+type Route struct {
+	Pattern   string   `json:"pattern"`
+	UseParams []string `json:"use_params,omitempty"` // NOTE: 特征键中保留实际值的路径参数，其余参数保留为模板
+}
+
+// UnmarshalJSON 支持字符串或对象
+func (rt *Route) UnmarshalJSON(data []byte) error {
+	var pattern string
+	if err := json.Unmarshal(data, &pattern); err == nil {
+		rt.Pattern, rt.UseParams = pattern, nil
+		return nil
+	}
+	type plain Route
+	return json.Unmarshal(data, (*plain)(rt))
+}
+
+// Path 根据匹配得到的路径参数生成特征键使用的路径，UseParams中的参数替换为实际值
+func (rt *Route) Path(ps httprouter.Params) string {
+	if len(rt.UseParams) == 0 {
+		return rt.Pattern
+	}
+	segments := strings.Split(rt.Pattern, "/")
+	for i, seg := range segments {
+		if len(seg) < 2 || (seg[0] != ':' && seg[0] != '*') {
+			continue
+		}
+		name := seg[1:]
+		if !rt.useParam(name) {
+			continue
+		}
+		value := ps.ByName(name)
+		if seg[0] == '*' {
+			value = strings.TrimPrefix(value, "/") // NOTE: httprouter的catch-all参数值以`/`开头
+		}
+		segments[i] = value
+	}
+	return strings.Join(segments, "/")
+}
+
+func (rt *Route) useParam(name string) bool {
+	for _, p := range rt.UseParams {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *Route) params() map[string]struct{} {
+	params := make(map[string]struct{})
+	for _, seg := range strings.Split(rt.Pattern, "/") {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			params[seg[1:]] = struct{}{}
+		}
+	}
+	return params
+}
+
+// routeTable 使用httprouter的路由树匹配路径，与httprouter的匹配规则完全一致
+type routeTable struct {
+	router *httprouter.Router
+}
+
+// routeWriter 用于从httprouter.Handle中取回匹配的Route
+type routeWriter struct {
+	http.ResponseWriter
+	route *Route
+}
+
+func newRouteTable(routes []*Route) (rt *routeTable, err error) {
+	router := httprouter.New()
+	defer func() {
+		// NOTE: httprouter在路由冲突或模板非法时panic
+		if v := recover(); v != nil {
+			rt, err = nil, errors.Errorf("invalid routes: %v", v)
+		}
+	}()
+	for _, route := range routes {
+		if route == nil {
+			return nil, errors.New("route is nil")
+		}
+		params := route.params()
+		for _, name := range route.UseParams {
+			if _, ok := params[name]; !ok {
+				return nil, errors.Errorf("route %s has no param %s", route.Pattern, name)
+			}
+		}
+		route := route
+		router.Handle(http.MethodGet, route.Pattern, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+			w.(*routeWriter).route = route
+		})
+	}
+	return &routeTable{router: router}, nil
+}
+
+// Match 返回匹配的Route及路径参数，未匹配时Route为nil
+// NOTE: 仅尾部`/`不同的路径(httprouter会重定向)视为未匹配
+func (t *routeTable) Match(path string) (*Route, httprouter.Params) {
+	handle, ps, _ := t.router.Lookup(http.MethodGet, path)
+	if handle == nil {
+		return nil, nil
+	}
+	w := &routeWriter{}
+	handle(w, nil, ps)
+	return w.route, ps
+}
@@ -0,0 +1,64 @@
+package eigenkey_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+)
+
+func TestHTTPRequestEigenkeyExtractorRoutes(t *testing.T) {
+	var extractor eigenkey.HTTPRequestEigenkeyExtractor
+	err := json.Unmarshal([]byte(`{
+		"request_extractor": {"use_method": true, "use_path": true},
+		"clean_path": true,
+		"routes": [
+			"/users/:id",
+			{"pattern": "/orgs/:org/users/:id", "use_params": ["org"]},
+			{"pattern": "/files/*path", "use_params": ["path"]},
+			"/static/*path"
+		]
+	}`), &extractor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/users/123", "GET:/users/:id"},
+		{"/users/456", "GET:/users/:id"},
+		{"/users//789", "GET:/users/:id"},
+		{"/orgs/acme/users/1", "GET:/orgs/acme/users/:id"},
+		{"/files/a/b.txt", "GET:/files/a/b.txt"},
+		{"/static/js/app.js", "GET:/static/%2Apath"}, // NOTE: URL()会转义`*`
+		{"/users/123/", "GET:/users/123/"},
+		{"/other/1", "GET:/other/1"},
+	}
+	for _, tc := range cases {
+		rq, _ := http.NewRequest("GET", "http://localhost"+tc.path, nil)
+		key, err := extractor.Eigenkey(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != tc.want {
+			t.Fatalf("%s should be %s, got %s", tc.path, tc.want, key)
+		}
+	}
+
+	bad := [][]*eigenkey.Route{
+		{{Pattern: "/users/:id", UseParams: []string{"name"}}},
+		{{Pattern: "/users/:id"}, {Pattern: "/users/new"}},
+		{{Pattern: "users"}},
+	}
+	for _, routes := range bad {
+		extractor := eigenkey.HTTPRequestEigenkeyExtractor{Routes: routes}
+		if err := extractor.Provision(); err == nil {
+			t.Fatalf("%s should fail", routes[len(routes)-1].Pattern)
+		}
+	}
+}
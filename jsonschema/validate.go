@@ -0,0 +1,29 @@
+package jsonschema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// DefaultValidate validate json `data` against json `schema` using `github.com/xeipuuv/gojsonschema`,
+// returns *ValidateFailedError with all failed details if data is not valid
+func DefaultValidate(schema, data []byte) error {
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	var details []string
+	for _, desc := range result.Errors() {
+		details = append(details, fmt.Sprintf("- %s", desc))
+	}
+	return NewValidateFailedError(strings.Join(details, "\n"))
+}
+
+var (
+	_ ValidateFunc = DefaultValidate
+)
@@ -0,0 +1,228 @@
+package mock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// AdminRule 管理API返回的规则，包含命中统计
+type AdminRule struct {
+	*Rule
+	Stats *RuleStats `json:"stats"`
+}
+
+// MarshalJSON 合并规则与统计字段
+func (ar AdminRule) MarshalJSON() ([]byte, error) {
+	data, err := ar.Rule.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["stats"], err = json.Marshal(ar.Stats)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// NewAdminHandler 新建RuleMatcher的管理API，用于运行时增删改查及启停Mock规则，规则格式同LoadRules
+// - GET    /rules               列出所有规则及命中统计
+// - POST   /rules               新增规则
+// - GET    /rules/:name         获取规则及命中统计
+// - PUT    /rules/:name         替换规则
+// - DELETE /rules/:name         删除规则
+// - POST   /rules/:name/enable  启用规则
+// - POST   /rules/:name/disable 禁用规则
+// - GET    /rules/:name/stats   获取命中统计，包括命中次数和最近匹配的请求特征值
+// - DELETE /stats               重置命中统计
+// - GET    /scenarios           列出场景及各会话的当前状态
+// - PUT    /scenarios/:name     设置会话中场景的状态，body为`{"session": "...", "state": "..."}`
+// - DELETE /scenarios           重置场景状态，指定`?session=`时只重置该会话，可指定多个
+// NOTE: 挂载到非根路径时使用http.StripPrefix
+func NewAdminHandler(matcher *RuleMatcher) http.Handler {
+	a := &admin{matcher: matcher}
+	router := httprouter.New()
+	router.GET("/rules", a.list)
+	router.POST("/rules", a.create)
+	router.GET("/rules/:name", a.get)
+	router.PUT("/rules/:name", a.update)
+	router.DELETE("/rules/:name", a.delete)
+	router.POST("/rules/:name/enable", a.enable(true))
+	router.POST("/rules/:name/disable", a.enable(false))
+	router.GET("/rules/:name/stats", a.stats)
+	router.DELETE("/stats", a.resetStats)
+	router.GET("/scenarios", a.scenarios)
+	router.PUT("/scenarios/:name", a.setScenario)
+	router.DELETE("/scenarios", a.resetScenarios)
+	return router
+}
+
+type admin struct {
+	matcher *RuleMatcher
+}
+
+func (a *admin) list(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rules := a.matcher.GetRules()
+	results := make([]AdminRule, 0, len(rules))
+	for _, rule := range rules {
+		results = append(results, AdminRule{Rule: rule, Stats: a.matcher.StatsOf(rule)})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (a *admin) get(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rule, err := a.matcher.GetRule(ps.ByName("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminRule{Rule: rule, Stats: a.matcher.Stats(rule.Name)})
+}
+
+func (a *admin) create(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rule, err := readRule(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = a.matcher.AddRule(rule)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (a *admin) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rule, err := readRule(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rule.Name == "" {
+		rule.Name = ps.ByName("name")
+	}
+	if rule.Name != ps.ByName("name") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "rule name mismatch"})
+		return
+	}
+	err = a.matcher.UpdateRule(rule)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (a *admin) delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := a.matcher.DeleteRule(ps.ByName("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) enable(enabled bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		err := a.matcher.EnableRule(ps.ByName("name"), enabled)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		a.get(w, r, ps)
+	}
+}
+
+func (a *admin) stats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rule, err := a.matcher.GetRule(ps.ByName("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.matcher.Stats(rule.Name))
+}
+
+func (a *admin) resetStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	a.matcher.ResetStats()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminScenarios 管理API返回的场景状态
+type AdminScenarios struct {
+	Scenarios []string                     `json:"scenarios"`
+	Sessions  map[string]map[string]string `json:"sessions"` // NOTE: 会话->场景->状态，未出现的场景为ScenarioStarted
+}
+
+func (a *admin) scenarios(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, AdminScenarios{
+		Scenarios: a.matcher.Scenarios(),
+		Sessions:  a.matcher.ScenarioStates(),
+	})
+}
+
+func (a *admin) setScenario(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var v struct {
+		Session string `json:"session"`
+		State   string `json:"state"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&v)
+	if err != nil {
+		writeError(w, errors.WithMessage(err, "unmarshal scenario state failed"))
+		return
+	}
+	if v.State == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "scenario state is empty"})
+		return
+	}
+	a.matcher.SetScenarioState(v.Session, ps.ByName("name"), v.State)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) resetScenarios(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	a.matcher.ResetScenarios(r.URL.Query()["session"]...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readRule(r *http.Request) (*Rule, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read rule failed")
+	}
+	var rule Rule
+	err = json.Unmarshal(data, &rule)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unmarshal rule failed")
+	}
+	return &rule, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, ErrRuleExists):
+		statusCode = http.StatusConflict
+	}
+	writeJSON(w, statusCode, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
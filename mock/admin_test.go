@@ -0,0 +1,98 @@
+package mock_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestAdminHandler(t *testing.T) {
+	matcher := &mock.RuleMatcher{}
+	if err := matcher.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	admin := httptest.NewServer(http.StripPrefix("/admin", mock.NewAdminHandler(matcher)))
+	defer admin.Close()
+	ts := httptest.NewServer(mock.NewHandler(matcher, nil))
+	defer ts.Close()
+
+	call := func(method, path, body string) (int, string) {
+		rq, _ := http.NewRequest(method, admin.URL+"/admin"+path, strings.NewReader(body))
+		rp, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(rp.Body)
+		rp.Body.Close()
+		return rp.StatusCode, string(data)
+	}
+	mocked := func() int {
+		rp, err := http.Get(ts.URL + "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp.Body.Close()
+		return rp.StatusCode
+	}
+
+	rule := `{"name": "a", "path": "/a", "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 201}}`
+	if code, body := call("POST", "/rules", rule); code != 201 {
+		t.Fatalf("create should be 201, got %d %s", code, body)
+	}
+	if code, _ := call("POST", "/rules", rule); code != 409 {
+		t.Fatalf("duplicate create should be 409, got %d", code)
+	}
+	if mocked() != 201 {
+		t.Fatal("should be mocked")
+	}
+	if code, body := call("PUT", "/rules/a", strings.Replace(rule, "201", "202", 1)); code != 200 {
+		t.Fatalf("update should be 200, got %d %s", code, body)
+	}
+	if mocked() != 202 {
+		t.Fatal("should be updated")
+	}
+	code, body := call("GET", "/rules/a", "")
+	if code != 200 || gjson.Get(body, "mocker.status_code").Int() != 202 || gjson.Get(body, "stats.hits").Int() != 2 {
+		t.Fatalf("get got %d %s", code, body)
+	}
+	if gjson.Get(body, "stats.last_eigenkeys.0").String() != "/a" {
+		t.Fatalf("last eigenkeys got %s", body)
+	}
+	if code, _ := call("POST", "/rules/a/disable", ""); code != 200 {
+		t.Fatal("disable should be 200")
+	}
+	if mocked() != 404 {
+		t.Fatal("should not be mocked when disabled")
+	}
+	if code, _ := call("POST", "/rules/a/enable", ""); code != 200 {
+		t.Fatal("enable should be 200")
+	}
+	if mocked() != 202 {
+		t.Fatal("should be mocked when enabled")
+	}
+	_, body = call("GET", "/rules", "")
+	if gjson.Get(body, "#").Int() != 1 || gjson.Get(body, "0.stats.hits").Int() != 3 {
+		t.Fatalf("list got %s", body)
+	}
+	if code, _ := call("PUT", "/rules/a", `{"name": "a", "path_regexp": "("}`); code != 400 {
+		t.Fatalf("invalid rule should be 400, got %d", code)
+	}
+	if code, _ := call("DELETE", "/stats", ""); code != 204 {
+		t.Fatal("reset stats should be 204")
+	}
+	if _, body = call("GET", "/rules/a/stats", ""); gjson.Get(body, "hits").Int() != 0 {
+		t.Fatalf("stats should be reset, got %s", body)
+	}
+	if code, _ := call("DELETE", "/rules/a", ""); code != 204 {
+		t.Fatal("delete should be 204")
+	}
+	if code, _ := call("GET", "/rules/a", ""); code != 404 {
+		t.Fatal("should be 404 after delete")
+	}
+}
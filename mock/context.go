@@ -0,0 +1,16 @@
+package mock
+
+import "context"
+
+type ctxKeyEigenkey struct{}
+
+// WithEigenkey 将Matcher计算得到的请求特征值存入context，Transport和Handler在调用ResponseMocker前设置
+func WithEigenkey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, ctxKeyEigenkey{}, key)
+}
+
+// EigenkeyFromContext 从context中获取请求特征值，不存在时返回false
+func EigenkeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(ctxKeyEigenkey{}).(string)
+	return key, ok
+}
@@ -0,0 +1,297 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+var (
+	// ErrConnectionReset 模拟连接被重置，由Options.AbortRate触发
+	ErrConnectionReset = errors.New("mock: connection reset by peer")
+
+	// ErrBodyTruncated 模拟响应Body被截断，由Options.TruncateRate触发
+	ErrBodyTruncated = errors.New("mock: response body truncated")
+)
+
+// 延迟分布类型
+const (
+	LatencyTypeUniform    = "uniform"
+	LatencyTypeNormal     = "normal"
+	LatencyTypePercentile = "percentile"
+)
+
+// LatencyDistribution 延迟分布，在Options.Latency基础上额外叠加采样得到的延迟
+// 1. uniform: 在[min, max)内均匀分布;
+// 2. normal: 均值为mean、标准差为stddev的正态分布，负值按0处理;
+// 3. percentile: 按分位点分段均匀分布，如p50=10ms、p99=200ms，percentile需升序且最后一个为100
+type LatencyDistribution struct {
+	Type        string              `json:"type"`
+	Min         utils.Duration      `json:"min,omitempty"`
+	Max         utils.Duration      `json:"max,omitempty"`
+	Mean        utils.Duration      `json:"mean,omitempty"`
+	Stddev      utils.Duration      `json:"stddev,omitempty"`
+	Percentiles []LatencyPercentile `json:"percentiles,omitempty"`
+}
+
+// LatencyPercentile 延迟分位点
+type LatencyPercentile struct {
+	Percentile float32        `json:"percentile"` // NOTE: (0, 100]
+	Latency    utils.Duration `json:"latency"`
+}
+
+// UnmarshalJSON 解析并校验分位点，percentile需在(0, 100]内严格升序且最后一个为100
+func (d *LatencyDistribution) UnmarshalJSON(data []byte) error {
+	type plain LatencyDistribution
+	err := json.Unmarshal(data, (*plain)(d))
+	if err != nil {
+		return err
+	}
+	if d.Type != LatencyTypePercentile {
+		return nil
+	}
+	if len(d.Percentiles) == 0 {
+		return errors.New("percentile latency distribution has no percentiles")
+	}
+	var prev float32
+	for _, p := range d.Percentiles {
+		if p.Percentile <= prev || p.Percentile > 100 {
+			return errors.Errorf("latency percentile %v should be in (%v, 100]", p.Percentile, prev)
+		}
+		prev = p.Percentile
+	}
+	if prev != 100 {
+		return errors.Errorf("last latency percentile should be 100, got %v", prev)
+	}
+	return nil
+}
+
+// Sample 按分布采样一个延迟
+func (d *LatencyDistribution) Sample() time.Duration {
+	if d == nil {
+		return 0
+	}
+	switch d.Type {
+	case LatencyTypeUniform:
+		return uniform(d.Min.Duration, d.Max.Duration)
+	case LatencyTypeNormal:
+		latency := time.Duration(rand.NormFloat64()*float64(d.Stddev.Duration)) + d.Mean.Duration
+		if latency < 0 {
+			return 0
+		}
+		return latency
+	case LatencyTypePercentile:
+		if len(d.Percentiles) == 0 {
+			return 0
+		}
+		weights := make([]float32, len(d.Percentiles))
+		var prev float32
+		for i, p := range d.Percentiles {
+			weights[i] = p.Percentile - prev
+			prev = p.Percentile
+		}
+		i := utils.WeightedChoice(weights)
+		var lower time.Duration
+		if i > 0 {
+			lower = d.Percentiles[i-1].Latency.Duration
+		}
+		return uniform(lower, d.Percentiles[i].Latency.Duration)
+	default:
+		return 0
+	}
+}
+
+func uniform(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return time.Duration(utils.Random(int(min), int(max)))
+}
+
+// chance 以概率rate返回true
+func chance(rate float32) bool {
+	if rate <= 0 {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	return utils.WeightedChoice([]float32{rate, 1 - rate}) == 0
+}
+
+// ShouldAbort 按AbortRate判断是否模拟连接重置
+func (o *Options) ShouldAbort() bool {
+	return o != nil && chance(o.AbortRate)
+}
+
+// ShouldError 按ErrorRate判断是否返回错误状态码
+func (o *Options) ShouldError() bool {
+	return o != nil && chance(o.ErrorRate)
+}
+
+// ErrorResponse 生成错误响应，状态码为ErrorStatusCode，默认500
+func (o *Options) ErrorResponse() *http.Response {
+	statusCode := http.StatusInternalServerError
+	if o != nil && o.ErrorStatusCode > 0 {
+		statusCode = o.ErrorStatusCode
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          NewResponseBodyFromString(http.StatusText(statusCode)),
+		ContentLength: -1,
+	}
+}
+
+// WrapBody 按TruncateRate截断以及按BytesPerSecond限速包装响应Body，限速等待时ctx结束则读取返回ctx.Err()
+func (o *Options) WrapBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if o == nil || body == nil {
+		return body
+	}
+	if chance(o.TruncateRate) {
+		body = &truncatedBody{ReadCloser: body, remain: o.TruncateBytes}
+	}
+	if o.BytesPerSecond > 0 {
+		body = &throttledBody{ReadCloser: body, ctx: ctx, bps: o.BytesPerSecond}
+	}
+	return body
+}
+
+// WrapResponseWriter 按TruncateRate截断以及按BytesPerSecond限速包装http.ResponseWriter，用于Handler代理到上游的场景
+// NOTE: 截断后Write返回ErrBodyTruncated，调用方可通过truncated判断并中断连接；限速等待时ctx结束则Write返回ctx.Err()
+func (o *Options) WrapResponseWriter(ctx context.Context, w http.ResponseWriter) (http.ResponseWriter, func() bool) {
+	if o == nil || (o.TruncateRate <= 0 && o.BytesPerSecond <= 0) {
+		return w, func() bool { return false }
+	}
+	fw := &faultResponseWriter{ResponseWriter: w, ctx: ctx, remain: -1, bps: o.BytesPerSecond}
+	if chance(o.TruncateRate) {
+		fw.remain = o.TruncateBytes
+	}
+	return fw, func() bool { return fw.truncated }
+}
+
+// truncatedBody 读取remain字节后返回ErrBodyTruncated
+type truncatedBody struct {
+	io.ReadCloser
+	remain int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		return 0, ErrBodyTruncated
+	}
+	if len(p) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= n
+	if err == io.EOF {
+		return n, err
+	}
+	if b.remain <= 0 && err == nil {
+		err = ErrBodyTruncated
+	}
+	return n, err
+}
+
+// throttledBody 每秒最多读取bps字节
+type throttledBody struct {
+	io.ReadCloser
+	ctx context.Context
+	bps int
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	chunk := throttleChunk(b.bps)
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := sleep(b.ctx, time.Duration(n)*time.Second/time.Duration(b.bps)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// faultResponseWriter 截断和限速的http.ResponseWriter
+type faultResponseWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	remain    int // NOTE: <0 表示不截断
+	bps       int
+	truncated bool
+}
+
+func (w *faultResponseWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if w.remain == 0 {
+			w.truncated = true
+			return written, ErrBodyTruncated
+		}
+		chunk := len(p)
+		if w.bps > 0 && chunk > throttleChunk(w.bps) {
+			chunk = throttleChunk(w.bps)
+		}
+		if w.remain > 0 && chunk > w.remain {
+			chunk = w.remain
+		}
+		n, err := w.ResponseWriter.Write(p[:chunk])
+		written += n
+		if w.remain > 0 {
+			w.remain -= n
+		}
+		if err != nil {
+			return written, err
+		}
+		if w.bps > 0 {
+			w.Flush()
+			if err = sleep(w.ctx, time.Duration(n)*time.Second/time.Duration(w.bps)); err != nil {
+				return written, err
+			}
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *faultResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sleep 等待d，ctx先结束时返回ctx.Err()
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttleChunk 限速时每次读写的字节数，约100ms一次
+func throttleChunk(bps int) int {
+	chunk := bps / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	return chunk
+}
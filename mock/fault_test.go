@@ -0,0 +1,141 @@
+package mock_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestLatencyDistribution(t *testing.T) {
+	var cases = []struct {
+		data     string
+		min, max time.Duration
+	}{
+		{`{"type": "uniform", "min": "10ms", "max": "20ms"}`, 10 * time.Millisecond, 20 * time.Millisecond},
+		{`{"type": "normal", "mean": "10ms", "stddev": "1ms"}`, 0, time.Second},
+		{`{"type": "percentile", "percentiles": [
+			{"percentile": 50, "latency": "10ms"},
+			{"percentile": 99, "latency": "50ms"},
+			{"percentile": 100, "latency": "100ms"}
+		]}`, 0, 100 * time.Millisecond},
+		{`{"type": "unknown"}`, 0, 0},
+	}
+	for _, tc := range cases {
+		var d mock.LatencyDistribution
+		if err := json.Unmarshal([]byte(tc.data), &d); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			latency := d.Sample()
+			if latency < tc.min || latency > tc.max {
+				t.Fatalf("%s: latency %s out of range", d.Type, latency)
+			}
+		}
+	}
+	for _, data := range []string{
+		`{"type": "percentile"}`,
+		`{"type": "percentile", "percentiles": [{"percentile": 99, "latency": "50ms"}, {"percentile": 50, "latency": "10ms"}]}`,
+		`{"type": "percentile", "percentiles": [{"percentile": 0, "latency": "1ms"}, {"percentile": 100, "latency": "10ms"}]}`,
+		`{"type": "percentile", "percentiles": [{"percentile": 50, "latency": "10ms"}, {"percentile": 120, "latency": "50ms"}]}`,
+		`{"type": "percentile", "percentiles": [{"percentile": 50, "latency": "10ms"}, {"percentile": 99, "latency": "50ms"}]}`,
+	} {
+		var d mock.LatencyDistribution
+		if err := json.Unmarshal([]byte(data), &d); err == nil {
+			t.Fatalf("should reject invalid percentiles: %s", data)
+		}
+	}
+}
+
+func newFaultMatcher(t *testing.T, options string) *mock.EigenkeyMatcher {
+	return newEigenkeyMatcher(t, nil, map[string]string{"/": `{
+		"response_mocker": "ResponseMockerBuilder",
+		"status_code": 200,
+		"body": "0123456789",
+		"options": ` + options + `
+	}`})
+}
+
+func TestFaultInjection(t *testing.T) {
+	// error status
+	matcher := newFaultMatcher(t, `{"error_rate": 1, "error_status_code": 503}`)
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	rp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != 503 {
+		t.Fatalf("should be 503, got %d", rp.StatusCode)
+	}
+
+	// abort
+	matcher = newFaultMatcher(t, `{"abort_rate": 1}`)
+	client = &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	_, err = client.Get("http://localhost/")
+	if !errors.Is(err, mock.ErrConnectionReset) {
+		t.Fatalf("should reset, got %v", err)
+	}
+	ts := httptest.NewServer(mock.NewHandler(matcher, nil))
+	_, err = http.Get(ts.URL)
+	ts.Close()
+	if err == nil {
+		t.Fatal("handler should abort connection")
+	}
+
+	// truncate
+	matcher = newFaultMatcher(t, `{"truncate_rate": 1, "truncate_bytes": 3}`)
+	client = &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	rp, err = client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rp.Body)
+	if !errors.Is(err, mock.ErrBodyTruncated) || string(body) != "012" {
+		t.Fatalf("should truncate, got %s %v", body, err)
+	}
+	ts = httptest.NewServer(mock.NewHandler(matcher, nil))
+	rp, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(rp.Body)
+	ts.Close()
+	if err == nil || string(body) != "012" {
+		t.Fatalf("handler should truncate, got %s %v", body, err)
+	}
+
+	// slow drip
+	matcher = newFaultMatcher(t, `{"bytes_per_second": 50}`)
+	client = &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	start := time.Now()
+	rp, err = client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(rp.Body)
+	if string(body) != "0123456789" || time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("should throttle, got %s in %s", body, time.Since(start))
+	}
+
+	// slow drip canceled
+	matcher = newFaultMatcher(t, `{"bytes_per_second": 1}`)
+	client = &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	start = time.Now()
+	rp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(rp.Body)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("throttle should stop when request canceled, got %v in %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,189 @@
+package mock
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+	"github.com/ccmonky/typemap"
+)
+
+var (
+	// DefaultFetchTimeout 未指定超时时每次请求的超时，避免缓慢的Mock平台拖垮服务
+	DefaultFetchTimeout = 10 * time.Second
+
+	// DefaultFetchBackoff 未指定退避时首次重试前的等待
+	DefaultFetchBackoff = 100 * time.Millisecond
+
+	// MaxFetchBackoff 重试等待翻倍的上限
+	MaxFetchBackoff = 30 * time.Second
+
+	// DefaultURLCache ResponseMockerFromURL和BodyFromURL共用的响应缓存
+	DefaultURLCache = NewURLCache()
+)
+
+// HTTPClient 获取远程Mock使用的客户端，*http.Client满足此接口
+// NOTE: 使用`typemap.MustRegister[HTTPClient](ctx, name, client)`注册，FetchOptions通过client字段引用，空名称为http.DefaultClient
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// FetchOptions 获取远程Mock的控制参数，nil时使用默认值
+type FetchOptions struct {
+	Client   string         `json:"client,omitempty"`    // NOTE: HTTPClient的注册名
+	Timeout  utils.Duration `json:"timeout,omitempty"`   // NOTE: 每次请求的超时，默认DefaultFetchTimeout
+	Retries  int            `json:"retries,omitempty"`   // NOTE: 网络错误或5xx时的重试次数
+	Backoff  utils.Duration `json:"backoff,omitempty"`   // NOTE: 首次重试前的等待，之后每次翻倍且不超过MaxFetchBackoff，默认DefaultFetchBackoff
+	CacheTTL utils.Duration `json:"cache_ttl,omitempty"` // NOTE: 大于0时缓存非5xx响应，缓存键包含client、cache_ttl和URL
+}
+
+// FetchedResponse 获取到的远程响应，Body已完整读取
+type FetchedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Response 生成新的http.Response，可多次调用
+func (fr *FetchedResponse) Response() *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(fr.StatusCode),
+		StatusCode:    fr.StatusCode,
+		Header:        fr.Header.Clone(),
+		Body:          NewResponseBodyFromBytes(fr.Body),
+		ContentLength: int64(len(fr.Body)),
+	}
+}
+
+// Fetch 使用ctx(通常为传入请求的context)GET url，按配置超时、重试和缓存
+func (o *FetchOptions) Fetch(ctx context.Context, url string) (*FetchedResponse, error) {
+	if o == nil {
+		o = &FetchOptions{}
+	}
+	key := o.cacheKey(url)
+	if o.CacheTTL.Duration > 0 {
+		if fr, ok := DefaultURLCache.Get(key); ok {
+			return fr, nil
+		}
+	}
+	client, err := typemap.Get[HTTPClient](context.Background(), o.Client)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get http client %s failed", o.Client)
+	}
+	backoff := o.Backoff.Duration
+	if backoff <= 0 {
+		backoff = DefaultFetchBackoff
+	}
+	var fr *FetchedResponse
+	for attempt := 0; ; attempt++ {
+		fr, err = o.fetchOnce(ctx, client, url)
+		if err == nil && fr.StatusCode < http.StatusInternalServerError {
+			break
+		}
+		if attempt >= o.Retries || ctx.Err() != nil {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.WithMessagef(ctx.Err(), "get %s canceled", url)
+		}
+		// NOTE: 逐次翻倍而不是backoff<<attempt，避免retries较大时移位溢出
+		if backoff *= 2; backoff > MaxFetchBackoff || backoff <= 0 {
+			backoff = MaxFetchBackoff
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if o.CacheTTL.Duration > 0 && fr.StatusCode < http.StatusInternalServerError {
+		DefaultURLCache.Set(key, fr, o.CacheTTL.Duration)
+	}
+	return fr, nil
+}
+
+// cacheKey DefaultURLCache为全局共享，不同client或cache_ttl的配置不应复用彼此的缓存
+func (o *FetchOptions) cacheKey(url string) string {
+	return o.Client + " " + o.CacheTTL.Duration.String() + " " + url
+}
+
+func (o *FetchOptions) fetchOnce(ctx context.Context, client HTTPClient, url string) (*FetchedResponse, error) {
+	timeout := o.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "new request for %s failed", url)
+	}
+	rp, err := client.Do(rq)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get %s failed", url)
+	}
+	defer rp.Body.Close()
+	body, err := ioutil.ReadAll(rp.Body)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read response from %s failed", url)
+	}
+	return &FetchedResponse{
+		StatusCode: rp.StatusCode,
+		Header:     rp.Header,
+		Body:       body,
+	}, nil
+}
+
+// URLCache 按键缓存远程响应，条目过期后在下次访问时删除
+// NOTE: FetchOptions使用client、cache_ttl和URL组成的键
+type URLCache struct {
+	lock    sync.Mutex
+	entries map[string]*urlCacheEntry
+}
+
+type urlCacheEntry struct {
+	response  *FetchedResponse
+	expiresAt time.Time
+}
+
+// NewURLCache 新建URLCache
+func NewURLCache() *URLCache {
+	return &URLCache{entries: make(map[string]*urlCacheEntry)}
+}
+
+// Get 获取未过期的缓存
+func (c *URLCache) Get(key string) (*FetchedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.response, true
+}
+
+// Set 缓存响应ttl时长
+func (c *URLCache) Set(key string, fr *FetchedResponse, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = &urlCacheEntry{response: fr, expiresAt: time.Now().Add(ttl)}
+}
+
+// Reset 清空缓存
+func (c *URLCache) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*urlCacheEntry)
+}
@@ -0,0 +1,161 @@
+package mock_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/typemap"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+type headerClient struct {
+	calls int32
+}
+
+func (c *headerClient) Do(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.calls, 1)
+	r.Header.Set("X-Client", "injected")
+	return http.DefaultClient.Do(r)
+}
+
+func TestResponseMockerFromURLFetch(t *testing.T) {
+	var calls int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Header().Set("X-Client", r.Header.Get("X-Client"))
+		io.WriteString(w, "remote"+r.URL.Path)
+	}))
+	defer remote.Close()
+	defer mock.DefaultURLCache.Reset()
+
+	client := &headerClient{}
+	err := typemap.Register[mock.HTTPClient](context.Background(), "test-fetch", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockOf := func(path, fetch string) mock.ResponseMocker {
+		mocker, err := mock.UnmarshalResponseMocker([]byte(`{
+			"response_mocker": "ResponseMockerFromURL",
+			"response_from_url": "` + remote.URL + path + `",
+			"fetch": ` + fetch + `
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mocker
+	}
+	rq, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	// retries with backoff until success
+	rp, err := mockOf("/flaky", `{"retries": 2, "backoff": "1ms"}`).Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if rp.StatusCode != 200 || string(body) != "remote/flaky" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("got %d %s after %d calls", rp.StatusCode, body, calls)
+	}
+
+	// backoff is capped and never overflows
+	defer func(d time.Duration) { mock.MaxFetchBackoff = d }(mock.MaxFetchBackoff)
+	mock.MaxFetchBackoff = time.Millisecond
+	atomic.StoreInt32(&calls, 0)
+	start := time.Now()
+	rp, err = mockOf("/down", `{"retries": 70, "backoff": "1ms"}`).Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != http.StatusBadGateway || atomic.LoadInt32(&calls) != 71 || time.Since(start) > 5*time.Second {
+		t.Fatalf("got %d after %d calls in %v", rp.StatusCode, calls, time.Since(start))
+	}
+
+	// timeout
+	start = time.Now()
+	_, err = mockOf("/slow", `{"timeout": "20ms"}`).Mock(rq)
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("should time out, got %v after %v", err, time.Since(start))
+	}
+
+	// request context propagation
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = mockOf("/slow", `{}`).Mock(rq.WithContext(ctx))
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("should be canceled by request context, got %v after %v", err, time.Since(start))
+	}
+
+	// cache and injected client
+	atomic.StoreInt32(&calls, 0)
+	mocker := mockOf("/cached", `{"client": "test-fetch", "cache_ttl": "1m"}`)
+	for i := 0; i < 3; i++ {
+		rp, err = mocker.Mock(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(rp.Body)
+		if string(body) != "remote/cached" || rp.Header.Get("X-Client") != "injected" {
+			t.Fatalf("got %s %v", body, rp.Header)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 || atomic.LoadInt32(&client.calls) != 1 {
+		t.Fatalf("should be cached, got %d remote calls", calls)
+	}
+
+	// cache entries are not shared across different ttls
+	atomic.StoreInt32(&calls, 0)
+	long, short := mockOf("/ttl", `{"cache_ttl": "1m"}`), mockOf("/ttl", `{"cache_ttl": "1ms"}`)
+	for _, m := range []mock.ResponseMocker{long, short, long} {
+		if _, err = m.Mock(rq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err = short.Mock(rq); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("short ttl should not reuse long ttl entry, got %d remote calls", calls)
+	}
+
+	// body_from_url shares the fetch options
+	builder, err := mock.UnmarshalResponseMocker([]byte(`{
+		"response_mocker": "ResponseMockerBuilder",
+		"status_code": 200,
+		"body_from_url": "` + remote.URL + `/flaky",
+		"fetch": {"timeout": "1s"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	rp, err = builder.Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != 200 || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("non-2xx body should be used as is, got %d after %d calls", rp.StatusCode, calls)
+	}
+}
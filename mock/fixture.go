@@ -0,0 +1,126 @@
+package mock
+
+import (
+	"context"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/typemap"
+)
+
+// DefaultFixtureLoader 默认FixtureLoader，从本地文件系统加载，路径相对于当前工作目录
+var DefaultFixtureLoader = NewFixtureLoader(nil)
+
+// fixtureContentTypes 补充mime.TypeByExtension未内置或因系统而异的类型
+var fixtureContentTypes = map[string]string{
+	".json":   "application/json",
+	".ndjson": "application/x-ndjson",
+	".txt":    "text/plain; charset=utf-8",
+	".html":   "text/html; charset=utf-8",
+	".xml":    "text/xml; charset=utf-8",
+	".csv":    "text/csv; charset=utf-8",
+	".yaml":   "application/yaml",
+	".yml":    "application/yaml",
+}
+
+// Fixture 从文件加载的响应Body
+type Fixture struct {
+	Body        []byte
+	ContentType string // NOTE: 根据扩展名推断，未知扩展名时为空
+}
+
+// FixtureLoader 从fs.FS(如embed.FS或os.DirFS)加载响应Body，首次加载后缓存在内存，便于完全离线地运行Mock
+// Usage:
+// 1. 使用`typemap.MustRegister[*FixtureLoader](ctx, name, loader)`注册，ResponseMockerBuilder通过fixtures字段引用;
+// 2. 空名称已注册为DefaultFixtureLoader，即本地文件系统
+type FixtureLoader struct {
+	FS fs.FS // NOTE: 为nil时使用本地文件系统
+
+	cache sync.Map // map[string]*Fixture
+}
+
+// NewFixtureLoader 新建FixtureLoader
+func NewFixtureLoader(fsys fs.FS) *FixtureLoader {
+	return &FixtureLoader{FS: fsys}
+}
+
+// GetFixtureLoader 获取注册的FixtureLoader
+func GetFixtureLoader(name string) (*FixtureLoader, error) {
+	loader, err := typemap.Get[*FixtureLoader](context.Background(), name)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get fixture loader %s failed", name)
+	}
+	return loader, nil
+}
+
+// Load 加载文件，已缓存时直接返回缓存
+func (l *FixtureLoader) Load(name string) (*Fixture, error) {
+	if v, ok := l.cache.Load(name); ok {
+		return v.(*Fixture), nil
+	}
+	body, err := l.readFile(name)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "load fixture %s failed", name)
+	}
+	fixture := &Fixture{
+		Body:        body,
+		ContentType: ContentTypeByExtension(name),
+	}
+	v, _ := l.cache.LoadOrStore(name, fixture)
+	return v.(*Fixture), nil
+}
+
+// Preload 加载并缓存dir下的所有文件，便于启动时发现缺失的fixture，dir为"."时加载全部
+func (l *FixtureLoader) Preload(dir string) error {
+	if l.FS == nil {
+		return errors.New("preload requires a fs.FS")
+	}
+	return fs.WalkDir(l.FS, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		_, err = l.Load(name)
+		return err
+	})
+}
+
+// Reset 清空缓存，下次Load时重新读取
+func (l *FixtureLoader) Reset() {
+	l.cache.Range(func(key, _ interface{}) bool {
+		l.cache.Delete(key)
+		return true
+	})
+}
+
+func (l *FixtureLoader) readFile(name string) ([]byte, error) {
+	if l.FS == nil {
+		return os.ReadFile(name)
+	}
+	// NOTE: fs.FS只接受不以`/`开头的规范路径
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	return fs.ReadFile(l.FS, name)
+}
+
+// ContentTypeByExtension 根据文件扩展名推断Content-Type，未知扩展名时返回空字符串
+func ContentTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if contentType, ok := fixtureContentTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,93 @@
+package mock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ccmonky/typemap"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestBodyFromFile(t *testing.T) {
+	memFS := fstest.MapFS{
+		"users/1.json": &fstest.MapFile{Data: []byte(`{"id": 1}`)},
+	}
+	err := typemap.Register[*mock.FixtureLoader](context.Background(), "test-mem", mock.NewFixtureLoader(memFS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = typemap.Register[*mock.FixtureLoader](context.Background(), "test-dir", mock.NewFixtureLoader(os.DirFS("testdata/fixtures")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		mocker, url, contentType, body string
+	}{
+		{`{"response_mocker": "ResponseMockerBuilder", "status_code": 200, "body_from_file": "testdata/fixtures/hello.txt"}`,
+			"http://localhost/", "text/plain; charset=utf-8", "hello fixture"},
+		{`{"response_mocker": "ResponseMockerBuilder", "status_code": 200, "fixtures": "test-mem", "body_from_file": "/users/1.json"}`,
+			"http://localhost/", "application/json", `{"id": 1}`},
+		{`{"response_mocker": "ResponseMockerBuilder", "status_code": 200, "fixtures": "test-dir", "body_from_file": "user.json", "template": true}`,
+			"http://localhost/?id=7", "application/json", `{"user": "7"}`},
+		{`{"response_mocker": "ResponseMockerBuilder", "status_code": 200, "fixtures": "test-dir", "body_from_file": "hello.txt", "header": {"Content-Type": ["text/markdown"]}}`,
+			"http://localhost/", "text/markdown", "hello fixture"},
+	}
+	for _, c := range cases {
+		mocker, err := mock.UnmarshalResponseMocker([]byte(c.mocker))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rq, _ := http.NewRequest("GET", c.url, nil)
+		for i := 0; i < 2; i++ {
+			rp, err := mocker.Mock(rq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(rp.Body)
+			if string(body) != c.body || rp.Header.Get("Content-Type") != c.contentType {
+				t.Fatalf("%s got %q %v", c.mocker, body, rp.Header)
+			}
+		}
+	}
+
+	mocker, _ := mock.UnmarshalResponseMocker([]byte(`{"response_mocker": "ResponseMockerBuilder", "fixtures": "test-mem", "body_from_file": "missing.json"}`))
+	rq, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if _, err = mocker.Mock(rq); err == nil {
+		t.Fatal("missing fixture should fail")
+	}
+}
+
+func TestFixtureLoaderCache(t *testing.T) {
+	memFS := fstest.MapFS{
+		"a.json":       &fstest.MapFile{Data: []byte(`{"a": 1}`)},
+		"dir/b.ndjson": &fstest.MapFile{Data: []byte("{}\n")},
+	}
+	loader := mock.NewFixtureLoader(memFS)
+	err := loader.Preload(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memFS["a.json"].Data = []byte(`{"a": 2}`)
+	fixture, err := loader.Load("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fixture.Body) != `{"a": 1}` {
+		t.Fatalf("should be cached, got %s", fixture.Body)
+	}
+	fixture, _ = loader.Load("dir/b.ndjson")
+	if fixture.ContentType != "application/x-ndjson" {
+		t.Fatalf("got %s", fixture.ContentType)
+	}
+	loader.Reset()
+	fixture, _ = loader.Load("a.json")
+	if string(fixture.Body) != `{"a": 2}` {
+		t.Fatalf("should reload after reset, got %s", fixture.Body)
+	}
+}
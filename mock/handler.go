@@ -0,0 +1,227 @@
+package mock
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Handler 服务端Mock处理器，通常以sidecar方式部署在上游服务前
+// 1. 匹配到非透明ResponseMocker时，直接写入其Mock响应;
+// 2. 未匹配或匹配到透明ResponseMocker时，交给Upstream(通常为httputil.ReverseProxy)处理，Upstream为nil时返回404;
+// 3. 匹配到实现了Recorder的透明ResponseMocker时，录制上游响应，实现了Transformer时修改上游响应后写出;
+// 4. 匹配到的ResponseMocker的Options指定了延迟和故障注入时，按其设置延迟、中断连接、返回错误状态码、截断或限速Body
+type Handler struct {
+	Matcher  Matcher
+	Upstream http.Handler
+
+	// ErrorHandler 处理匹配或Mock过程中的错误，为nil时返回502
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+	// ErrorLog 记录响应写出后发生的错误(如录制失败)，为nil时使用log包的标准logger
+	ErrorLog *log.Logger
+}
+
+// NewHandler 新建Handler，target不为nil时使用httputil.NewSingleHostReverseProxy代理到target
+func NewHandler(matcher Matcher, target *url.URL) *Handler {
+	h := &Handler{
+		Matcher: matcher,
+	}
+	if target != nil {
+		h.Upstream = httputil.NewSingleHostReverseProxy(target)
+	}
+	return h
+}
+
+// ServeHTTP 实现http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, mocker, err := matchRequest(h.Matcher, r)
+	if err != nil {
+		h.error(w, r, errors.WithMessagef(err, "match mock for %s failed", r.URL))
+		return
+	}
+	if mocker == nil {
+		h.proxy(w, r)
+		return
+	}
+	r = r.WithContext(WithEigenkey(r.Context(), key))
+	mocker, options, err := Resolve(mocker, r)
+	if err != nil {
+		h.error(w, r, errors.WithMessagef(err, "resolve mock for %s failed", r.URL))
+		return
+	}
+	err = options.Wait(r.Context())
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	if options.ShouldAbort() {
+		panic(http.ErrAbortHandler)
+	}
+	var rp *http.Response
+	switch {
+	case options.ShouldError():
+		rp = options.ErrorResponse()
+	case mocker.IsTransparent():
+		fw, truncated := options.WrapResponseWriter(r.Context(), w)
+		h.proxyTransparent(fw, r, key, mocker)
+		if truncated() {
+			abort(w)
+		}
+		return
+	default:
+		rp, err = mocker.Mock(r)
+		if err != nil {
+			h.error(w, r, errors.WithMessagef(err, "mock response for %s failed", r.URL))
+			return
+		}
+	}
+	rp.Body = options.WrapBody(r.Context(), rp.Body)
+	err = WriteResponse(w, rp)
+	if err != nil {
+		if errors.Is(err, ErrBodyTruncated) {
+			abort(w)
+		}
+		// NOTE: 响应头已写出，仅记录日志
+		h.logf("mock: write mock response for %s failed: %v", r.URL, err)
+	}
+}
+
+func (h *Handler) proxy(w http.ResponseWriter, r *http.Request) {
+	if h.Upstream == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.Upstream.ServeHTTP(w, r)
+}
+
+// proxyTransparent 交给Upstream处理，如果mocker实现了Recorder则录制上游响应，实现了Transformer则缓存上游响应并修改后写出
+func (h *Handler) proxyTransparent(w http.ResponseWriter, r *http.Request, key string, mocker ResponseMocker) {
+	recorder, isRecorder := mocker.(Recorder)
+	transformer, isTransformer := mocker.(Transformer)
+	if (!isRecorder && !isTransformer) || h.Upstream == nil {
+		h.proxy(w, r)
+		return
+	}
+	if !isTransformer {
+		cw := &captureResponseWriter{ResponseWriter: w}
+		h.Upstream.ServeHTTP(cw, r)
+		if cw.tooLarge {
+			h.logf("mock: record response for %s failed: %v", r.URL, ErrRecordingTooLarge)
+			return
+		}
+		h.record(r, recorder, key, cw.Response())
+		return
+	}
+	bw := &bufferResponseWriter{header: http.Header{}}
+	h.Upstream.ServeHTTP(bw, r)
+	rp := bw.Response()
+	if isRecorder {
+		h.record(r, recorder, key, rp)
+	}
+	rp, err := transformer.Transform(r, rp)
+	if err != nil {
+		h.error(w, r, errors.WithMessagef(err, "transform response for %s failed", r.URL))
+		return
+	}
+	err = WriteResponse(w, rp)
+	if err != nil {
+		h.logf("mock: write transformed response for %s failed: %v", r.URL, err)
+	}
+}
+
+func (h *Handler) record(r *http.Request, recorder Recorder, key string, rp *http.Response) {
+	err := recorder.Record(key, rp)
+	if err != nil {
+		// NOTE: 响应已写出或不影响响应，仅记录日志
+		h.logf("mock: record response for %s failed: %v", r.URL, err)
+	}
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// abort 刷出已写入的部分响应后中断连接，模拟Body被截断
+func abort(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if h.ErrorHandler != nil {
+		h.ErrorHandler(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// WriteResponse 将Mock响应写入http.ResponseWriter，并关闭响应Body，流式响应(TransferEncoding为chunked)每次读取后flush，
+// rp.Trailer在写出Body后作为trailer写出
+func WriteResponse(w http.ResponseWriter, rp *http.Response) error {
+	header := w.Header()
+	for k, vs := range rp.Header {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	for k := range rp.Trailer {
+		header.Add("Trailer", k)
+	}
+	statusCode := rp.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	err := writeBody(w, rp)
+	for k, vs := range rp.Trailer {
+		header[k] = vs
+	}
+	return err
+}
+
+func writeBody(w http.ResponseWriter, rp *http.Response) error {
+	if rp.Body == nil {
+		return nil
+	}
+	defer rp.Body.Close()
+	if flusher, ok := w.(http.Flusher); ok && isStreaming(rp) {
+		return copyFlush(w, flusher, rp.Body)
+	}
+	_, err := io.Copy(w, rp.Body)
+	return err
+}
+
+// copyFlush 每次读取后写出并flush，用于流式响应
+func copyFlush(w io.Writer, flusher http.Flusher, body io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	_ http.Handler = (*Handler)(nil)
+)
@@ -0,0 +1,68 @@
+package mock_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		io.WriteString(w, "real:"+r.PostForm.Get("a"))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	matcher := newEigenkeyMatcher(t, &eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{
+			UseMethod:    true,
+			UsePath:      true,
+			UseArguments: []string{"a"},
+		},
+	}, nil)
+	matcher.Set("GET:/mocked", &mock.ResponseMockerBuilder{
+		StatusCode: 418,
+		Header:     http.Header{"X-Mock": []string{"yes"}},
+		Body:       "mocked",
+	})
+	matcher.Set("POST:/transparent?a=1", new(mock.TransparentResponseMocker))
+	ts := httptest.NewServer(mock.NewHandler(matcher, target))
+	defer ts.Close()
+
+	rp, err := http.Get(ts.URL + "/mocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if rp.StatusCode != 418 || string(body) != "mocked" || rp.Header.Get("X-Mock") != "yes" {
+		t.Fatalf("should be mocked, got %d %s", rp.StatusCode, body)
+	}
+
+	for _, path := range []string{"/transparent", "/unmatched"} {
+		rp, err = http.PostForm(ts.URL+path, url.Values{"a": []string{"1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(rp.Body)
+		if string(body) != "real:1" {
+			t.Fatalf("%s should be proxied with body refilled, got %s", path, body)
+		}
+	}
+
+	ts2 := httptest.NewServer(mock.NewHandler(matcher, nil))
+	defer ts2.Close()
+	rp, err = http.Get(ts2.URL + "/unmatched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != http.StatusNotFound {
+		t.Fatalf("should be 404 without upstream, got %d", rp.StatusCode)
+	}
+}
@@ -0,0 +1,398 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/utils"
+)
+
+// HAR HTTP Archive 1.2，仅包含Mock需要的字段，参考`http://www.softwareishard.com/blog/har-12-spec/`
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog HAR日志
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator HAR生成工具
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry 一次请求响应
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // NOTE: 毫秒
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Comment         string      `json:"comment,omitempty"` // NOTE: HARCollector截断Body时说明被截断的部分
+}
+
+// HARRequest HAR请求
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse HAR响应
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue 头或查询参数
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData 请求Body
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// HARContent 响应Body，Encoding为base64时Text为base64编码
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// harSkipHeaders 导入时忽略的响应头，HAR中的content.text已解码，这些头与Mock响应不再一致
+var harSkipHeaders = map[string]struct{}{
+	"Content-Length":    {},
+	"Content-Encoding":  {},
+	"Transfer-Encoding": {},
+	"Connection":        {},
+}
+
+// LoadHAR 解析HAR文件
+func LoadHAR(data []byte) (*HAR, error) {
+	var har HAR
+	err := json.Unmarshal(data, &har)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unmarshal har failed")
+	}
+	return &har, nil
+}
+
+// HARToRules 将HAR转换为EigenkeyMatcher的规则表：请求特征值使用extractor根据每个entry的请求计算，ResponseMocker为根据响应构建的ResponseMockerBuilder
+// NOTE: 多个entry特征值相同时，按出现顺序组合为sequence模式的MultiResponseMocker；extractor为nil时使用仅包含path的默认提取器
+func HARToRules(har *HAR, extractor *eigenkey.HTTPRequestEigenkeyExtractor) (map[string]ResponseMocker, error) {
+	if extractor == nil {
+		extractor = &eigenkey.HTTPRequestEigenkeyExtractor{}
+		err := extractor.Provision()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var keys []string
+	grouped := make(map[string][]ResponseMocker)
+	for i, entry := range har.Log.Entries {
+		r, err := entry.Request.HTTPRequest()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "convert request of har entry %d failed", i)
+		}
+		key, err := extractor.Eigenkey(r)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "compute eigenkey of har entry %d failed", i)
+		}
+		mocker, err := entry.Response.ResponseMocker()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "convert response of har entry %d failed", i)
+		}
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], mocker)
+	}
+	rules := make(map[string]ResponseMocker, len(keys))
+	for _, key := range keys {
+		mockers := grouped[key]
+		if len(mockers) == 1 {
+			rules[key] = mockers[0]
+			continue
+		}
+		multi := &MultiResponseMocker{Mode: SelectSequence}
+		for _, mocker := range mockers {
+			multi.Mockers = append(multi.Mockers, &MultiResponseEntry{Mocker: mocker})
+		}
+		rules[key] = multi
+	}
+	return rules, nil
+}
+
+// HTTPRequest 将HAR请求转换为http.Request
+func (hr HARRequest) HTTPRequest() (*http.Request, error) {
+	var body io.Reader
+	if hr.PostData != nil {
+		body = strings.NewReader(hr.PostData.Text)
+	}
+	r, err := http.NewRequest(hr.Method, hr.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hr.Headers {
+		if strings.HasPrefix(h.Name, ":") { // NOTE: 忽略HTTP/2伪头
+			continue
+		}
+		r.Header.Add(h.Name, h.Value)
+	}
+	if hr.PostData != nil && hr.PostData.MimeType != "" {
+		r.Header.Set("Content-Type", hr.PostData.MimeType)
+	}
+	return r, nil
+}
+
+// ResponseMocker 将HAR响应转换为ResponseMockerBuilder
+func (hr HARResponse) ResponseMocker() (*ResponseMockerBuilder, error) {
+	header := http.Header{}
+	for _, h := range hr.Headers {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		name := http.CanonicalHeaderKey(h.Name)
+		if _, ok := harSkipHeaders[name]; ok {
+			continue
+		}
+		header.Add(name, h.Value)
+	}
+	if hr.Content.MimeType != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", hr.Content.MimeType)
+	}
+	body := hr.Content.Text
+	if hr.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, errors.WithMessage(err, "decode base64 content failed")
+		}
+		body = string(decoded)
+	}
+	return &ResponseMockerBuilder{
+		StatusCode: hr.Status,
+		Header:     header,
+		Body:       body,
+	}, nil
+}
+
+// NewHAREntry 根据请求响应生成HAREntry，reqBody和respBody由调用方读取
+func NewHAREntry(r *http.Request, reqBody []byte, rp *http.Response, respBody []byte, started time.Time, elapsed time.Duration) *HAREntry {
+	entry := &HAREntry{
+		StartedDateTime: started,
+		Time:            float64(elapsed) / float64(time.Millisecond),
+		Request: HARRequest{
+			Method:      r.Method,
+			URL:         r.URL.String(),
+			HTTPVersion: protoOrDefault(r.Proto),
+			Headers:     harNameValues(r.Header),
+			QueryString: harNameValues(r.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: HARResponse{
+			Status:      rp.StatusCode,
+			StatusText:  http.StatusText(rp.StatusCode),
+			HTTPVersion: protoOrDefault(rp.Proto),
+			Headers:     harNameValues(rp.Header),
+			Content: HARContent{
+				Size:     len(respBody),
+				MimeType: rp.Header.Get("Content-Type"),
+			},
+			HeadersSize: -1,
+			BodySize:    len(respBody),
+		},
+	}
+	if len(reqBody) > 0 {
+		entry.Request.PostData = &HARPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     string(reqBody),
+		}
+	}
+	if utf8.Valid(respBody) {
+		entry.Response.Content.Text = string(respBody)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(respBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+	return entry
+}
+
+// HARCollector 收集经过的请求响应并导出为HAR，通常包装Transport以导出Mock或录制的流量
+type HARCollector struct {
+	Base http.RoundTripper // NOTE: 为nil时使用http.DefaultTransport
+
+	lock    sync.Mutex
+	entries []*HAREntry
+}
+
+// NewHARCollector 新建HARCollector
+func NewHARCollector(base http.RoundTripper) *HARCollector {
+	return &HARCollector{Base: base}
+}
+
+// RoundTrip 实现http.RoundTripper
+// NOTE: 使用请求的副本转发，不修改调用方的请求；响应Body边读边缓存，读到EOF或Close时记录，不阻塞流式响应
+// NOTE: 请求或响应Body超过utils.DefaultMaxBodySize时截断记录，请求和响应本身不受影响
+func (c *HARCollector) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	reqBody, err := utils.ReadAndRestoreBody(r)
+	reqTruncated := errors.Is(err, utils.ErrBodyTooLarge)
+	if err != nil && !reqTruncated {
+		return nil, errors.WithMessagef(err, "read request body of %s failed", r.URL)
+	}
+	base := c.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	started := time.Now()
+	rp, err := base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(started)
+	rp.Request = req
+	snapshot := *rp
+	snapshot.Header = rp.Header.Clone()
+	save := func(respBody []byte, respTruncated bool) {
+		entry := NewHAREntry(r, reqBody, &snapshot, respBody, started, elapsed)
+		switch {
+		case reqTruncated && respTruncated:
+			entry.Comment = "request and response body truncated"
+		case reqTruncated:
+			entry.Comment = "request body truncated"
+		case respTruncated:
+			entry.Comment = "response body truncated"
+		}
+		c.lock.Lock()
+		c.entries = append(c.entries, entry)
+		c.lock.Unlock()
+	}
+	if rp.Body == nil || rp.Body == http.NoBody {
+		save(nil, false)
+		return rp, nil
+	}
+	rp.Body = &harBody{ReadCloser: rp.Body, save: save}
+	return rp, nil
+}
+
+// harBody 包装响应Body，读取时同时缓存最多utils.DefaultMaxBodySize字节，读到EOF或Close时调用save
+type harBody struct {
+	io.ReadCloser
+	body      bytes.Buffer
+	truncated bool
+	once      sync.Once
+	save      func(body []byte, truncated bool)
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.truncated {
+		room := int(utils.DefaultMaxBodySize) - b.body.Len()
+		if n > room {
+			b.body.Write(p[:room])
+			b.truncated = true
+		} else {
+			b.body.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *harBody) finish() {
+	b.once.Do(func() {
+		b.save(b.body.Bytes(), b.truncated)
+	})
+}
+
+// HAR 返回已收集的HAR
+func (c *HARCollector) HAR() *HAR {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "github.com/ccmonky/pkg/mock", Version: "1.0"},
+			Entries: append([]*HAREntry(nil), c.entries...),
+		},
+	}
+}
+
+// WriteHAR 将已收集的HAR写入w
+func (c *HARCollector) WriteHAR(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.HAR())
+}
+
+// Reset 清空已收集的请求响应
+func (c *HARCollector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = nil
+}
+
+func harNameValues(values map[string][]string) []HARNameValue {
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	nvs := []HARNameValue{}
+	for _, k := range names {
+		for _, v := range values[k] {
+			nvs = append(nvs, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+func protoOrDefault(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+var (
+	_ http.RoundTripper = (*HARCollector)(nil)
+)
@@ -0,0 +1,197 @@
+package mock_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/mock"
+	"github.com/ccmonky/pkg/utils"
+)
+
+const testHAR = `{
+  "log": {
+    "version": "1.2",
+    "creator": {"name": "devtools", "version": "1.0"},
+    "entries": [
+      {
+        "startedDateTime": "2023-01-01T00:00:00Z",
+        "time": 12.5,
+        "request": {
+          "method": "GET",
+          "url": "https://api.example.com/users?id=1",
+          "httpVersion": "HTTP/2",
+          "headers": [{"name": ":authority", "value": "api.example.com"}, {"name": "Accept", "value": "application/json"}],
+          "queryString": [{"name": "id", "value": "1"}]
+        },
+        "response": {
+          "status": 200,
+          "statusText": "OK",
+          "httpVersion": "HTTP/2",
+          "headers": [{"name": "content-encoding", "value": "gzip"}, {"name": "x-trace", "value": "abc"}],
+          "content": {"size": 11, "mimeType": "application/json", "text": "eyJpZCI6MX0=", "encoding": "base64"}
+        }
+      },
+      {
+        "startedDateTime": "2023-01-01T00:00:01Z",
+        "time": 3,
+        "request": {"method": "POST", "url": "https://api.example.com/orders", "httpVersion": "HTTP/2", "headers": [],
+          "postData": {"mimeType": "application/json", "text": "{}"}},
+        "response": {"status": 500, "statusText": "", "httpVersion": "HTTP/2", "headers": [], "content": {"size": 0, "mimeType": "text/plain"}}
+      },
+      {
+        "startedDateTime": "2023-01-01T00:00:02Z",
+        "time": 3,
+        "request": {"method": "POST", "url": "https://api.example.com/orders", "httpVersion": "HTTP/2", "headers": [],
+          "postData": {"mimeType": "application/json", "text": "{}"}},
+        "response": {"status": 201, "statusText": "", "httpVersion": "HTTP/2", "headers": [], "content": {"size": 2, "mimeType": "application/json", "text": "{}"}}
+      }
+    ]
+  }
+}`
+
+func TestHAR(t *testing.T) {
+	har, err := mock.LoadHAR([]byte(testHAR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor := &eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UseMethod: true, UsePath: true},
+	}
+	if err := extractor.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := mock.HARToRules(har, extractor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("should have 2 rules, got %d", len(rules))
+	}
+	if _, ok := rules["POST:/orders"].(*mock.MultiResponseMocker); !ok {
+		t.Fatal("duplicate eigenkeys should be combined")
+	}
+
+	matcher := mock.NewEigenkeyMatcher(extractor)
+	for key, mocker := range rules {
+		matcher.Set(key, mocker)
+	}
+	collector := mock.NewHARCollector(mock.NewTransport(matcher, nil))
+	client := &http.Client{Transport: collector}
+
+	rp, err := client.Get("http://localhost/users?id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != `{"id":1}` || rp.Header.Get("X-Trace") != "abc" || rp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected response %s %v", body, rp.Header)
+	}
+	if rp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal("content type should be set from mime type")
+	}
+	for _, code := range []int{500, 201, 201} {
+		rp, err = client.Post("http://localhost/orders", "application/json", bytes.NewReader([]byte("{}")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp.Body.Close()
+		if rp.StatusCode != code {
+			t.Fatalf("should be %d, got %d", code, rp.StatusCode)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := collector.WriteHAR(buf); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := mock.LoadHAR(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Log.Entries) != 4 {
+		t.Fatalf("should export 4 entries, got %d", len(exported.Log.Entries))
+	}
+	if exported.Log.Entries[1].Request.PostData.Text != "{}" || exported.Log.Entries[1].Response.Status != 500 {
+		t.Fatal("unexpected exported entry")
+	}
+	rules, err = mock.HARToRules(exported, extractor)
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("exported har should be importable, %v", err)
+	}
+	// NOTE: 不修改调用方的请求
+	rq, _ := http.NewRequest("POST", "http://localhost/orders", bytes.NewReader([]byte("{}")))
+	reqBody := rq.Body
+	if _, err = collector.RoundTrip(rq); err != nil {
+		t.Fatal(err)
+	}
+	if rq.Body != reqBody {
+		t.Fatal("request body of caller should not be replaced")
+	}
+
+	rules, err = mock.HARToRules(har, nil)
+	if err != nil || rules["/orders"] == nil {
+		t.Fatal("nil extractor should use default extractor", err)
+	}
+	_, err = mock.HARToRules(har, &eigenkey.HTTPRequestEigenkeyExtractor{})
+	if !errors.Is(err, eigenkey.ErrNotProvisioned) {
+		t.Fatal("unprovisioned extractor should fail", err)
+	}
+}
+
+func TestHARCollectorStreaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo" {
+			io.Copy(w, r.Body)
+			return
+		}
+		io.WriteString(w, "first;")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "last")
+	}))
+	defer upstream.Close()
+	collector := mock.NewHARCollector(nil)
+	client := &http.Client{Transport: collector}
+
+	// NOTE: 流式响应无需等待上游结束，读完后记录完整的Body
+	rp, err := client.Get(upstream.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("first;"))
+	if _, err = io.ReadFull(rp.Body, first); err != nil || string(first) != "first;" {
+		t.Fatalf("should read first chunk before upstream ends, got %s %v", first, err)
+	}
+	close(release)
+	ioutil.ReadAll(rp.Body)
+	rp.Body.Close()
+	entries := collector.HAR().Log.Entries
+	if len(entries) != 1 || entries[0].Response.Content.Text != "first;last" {
+		t.Fatalf("should collect whole stream, got %+v", entries)
+	}
+
+	// NOTE: Body超过上限时请求照常转发，HAR中截断记录
+	defer func(n int64) { utils.DefaultMaxBodySize = n }(utils.DefaultMaxBodySize)
+	utils.DefaultMaxBodySize = 4
+	rp, err = client.Post(upstream.URL+"/echo", "text/plain", strings.NewReader("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	rp.Body.Close()
+	if string(body) != "12345" {
+		t.Fatalf("oversized request should pass through, got %s", body)
+	}
+	entry := collector.HAR().Log.Entries[1]
+	if entry.Request.PostData.Text != "1234" || entry.Response.Content.Text != "1234" || !strings.Contains(entry.Comment, "truncated") {
+		t.Fatalf("should collect truncated entry, got %+v", entry)
+	}
+}
@@ -0,0 +1,28 @@
+package mock_test
+
+import (
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/mock"
+)
+
+// newEigenkeyMatcher 新建已Provision的EigenkeyMatcher，extractor为nil时仅使用path，mockers的key为请求特征值，value为ResponseMocker的json
+func newEigenkeyMatcher(t *testing.T, extractor *eigenkey.HTTPRequestEigenkeyExtractor, mockers map[string]string) *mock.EigenkeyMatcher {
+	t.Helper()
+	if extractor == nil {
+		extractor = &eigenkey.HTTPRequestEigenkeyExtractor{}
+	}
+	matcher := mock.NewEigenkeyMatcher(extractor)
+	if err := matcher.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	for key, data := range mockers {
+		mocker, err := mock.UnmarshalResponseMocker([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		matcher.Set(key, mocker)
+	}
+	return matcher
+}
@@ -2,18 +2,47 @@ package mock
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/ccmonky/pkg/eigenkey"
 	"github.com/ccmonky/typemap"
 )
 
 func init() {
 	typemap.MustRegisterType[Matcher]()
 	typemap.MustRegisterType[ResponseMocker]()
+	typemap.MustRegisterType[*EigenkeyMatcher](typemap.WithDependencies([]string{
+		typemap.GetTypeIdString[ResponseMocker](),
+		typemap.GetTypeIdString[*eigenkey.HTTPRequestEigenkeyExtractor](),
+	}))
+	typemap.MustRegisterType[*RuleMatcher](typemap.WithDependencies([]string{
+		typemap.GetTypeIdString[ResponseMocker](),
+		typemap.GetTypeIdString[*eigenkey.HTTPRequestEigenkeyExtractor](),
+	}))
+	typemap.MustRegisterType[*GRPCMatcher](typemap.WithDependencies([]string{
+		typemap.GetTypeIdString[ResponseMocker](),
+	}))
+	typemap.MustRegisterType[*JSONRPCMatcher](typemap.WithDependencies([]string{
+		typemap.GetTypeIdString[ResponseMocker](),
+	}))
+
+	typemap.MustRegisterType[HTTPClient]()
+	typemap.MustRegister[HTTPClient](context.Background(), "", http.DefaultClient)
+	typemap.MustRegisterType[*FixtureLoader]()
+	typemap.MustRegister[*FixtureLoader](context.Background(), "", DefaultFixtureLoader)
+	typemap.MustRegisterType[RecordingStore]()
 
 	generators := []ResponseMocker{
 		new(TransparentResponseMocker),
 		new(ResponseMockerFromURL),
 		new(ResponseMockerBuilder),
+		new(RecordingResponseMocker),
+		new(ReplayResponseMocker),
+		new(MultiResponseMocker),
+		new(OpenAPIResponseMocker),
+		new(TransformResponseMocker),
+		new(GRPCResponseMocker),
+		new(JSONRPCResponseMocker),
 	}
 	for _, gen := range generators {
 		typemap.MustRegister[ResponseMocker](context.Background(), gen.ID(), gen)
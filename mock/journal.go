@@ -0,0 +1,302 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// Call Journal记录的一次请求
+type Call struct {
+	Time     time.Time   `json:"time"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Path     string      `json:"path"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+	Eigenkey string      `json:"eigenkey"`
+	Rule     string      `json:"rule,omitempty"` // NOTE: 匹配的规则名，仅RuleMatcher可用
+	Matched  bool        `json:"matched"`
+	Error    string      `json:"error,omitempty"` // NOTE: 匹配出错时的错误信息
+}
+
+// String 返回便于阅读的请求描述
+func (c *Call) String() string {
+	s := c.Method + " " + c.URL
+	if c.Rule != "" {
+		s += " rule=" + c.Rule
+	}
+	if !c.Matched {
+		s += " (unmatched)"
+	}
+	return s
+}
+
+// CallFilter 过滤Journal中的请求
+type CallFilter func(*Call) bool
+
+// CallWithMethod 过滤method
+func CallWithMethod(method string) CallFilter {
+	return func(c *Call) bool {
+		return strings.EqualFold(c.Method, method)
+	}
+}
+
+// CallWithPath 过滤path
+func CallWithPath(path string) CallFilter {
+	return func(c *Call) bool {
+		return c.Path == path
+	}
+}
+
+// CallWithRule 过滤匹配的规则名
+func CallWithRule(name string) CallFilter {
+	return func(c *Call) bool {
+		return c.Rule == name
+	}
+}
+
+// CallWithEigenkey 过滤请求特征值
+func CallWithEigenkey(key string) CallFilter {
+	return func(c *Call) bool {
+		return c.Eigenkey == key
+	}
+}
+
+// CallWithHeader 过滤请求头，value为空时只要求存在该请求头
+func CallWithHeader(name, value string) CallFilter {
+	return func(c *Call) bool {
+		values, ok := c.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CallUnmatched 过滤未匹配任何规则的请求
+func CallUnmatched() CallFilter {
+	return func(c *Call) bool {
+		return !c.Matched
+	}
+}
+
+// Journal 请求日志，记录经过JournalMatcher的每个请求，通常用于测试中断言Mock的调用情况
+type Journal struct {
+	Limit int // NOTE: 最多保留的请求数，超出时丢弃最早的请求，0表示不限制
+
+	lock  sync.RWMutex
+	calls []*Call
+}
+
+// NewJournal 新建Journal
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// Record 记录一次请求
+func (j *Journal) Record(call *Call) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.calls = append(j.calls, call)
+	if j.Limit > 0 && len(j.calls) > j.Limit {
+		j.calls = append([]*Call(nil), j.calls[len(j.calls)-j.Limit:]...)
+	}
+}
+
+// Calls 返回满足所有filters的请求，按记录顺序
+func (j *Journal) Calls(filters ...CallFilter) []*Call {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	var calls []*Call
+	for _, call := range j.calls {
+		if matchCall(call, filters) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Count 返回满足所有filters的请求数
+func (j *Journal) Count(filters ...CallFilter) int {
+	return len(j.Calls(filters...))
+}
+
+// Unmatched 返回未匹配任何规则的请求
+func (j *Journal) Unmatched() []*Call {
+	return j.Calls(CallUnmatched())
+}
+
+// Reset 清空请求日志
+func (j *Journal) Reset() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.calls = nil
+}
+
+// TestingT 断言使用的测试接口，*testing.T满足此接口
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type tHelper interface {
+	Helper()
+}
+
+// AssertCalledTimes 断言满足filters的请求恰好n次
+func (j *Journal) AssertCalledTimes(t TestingT, n int, filters ...CallFilter) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	calls := j.Calls(filters...)
+	if len(calls) != n {
+		t.Errorf("mock: expected %d calls, got %d\n%s", n, len(calls), j.dump())
+		return false
+	}
+	return true
+}
+
+// AssertCalled 断言满足filters的请求至少一次
+func (j *Journal) AssertCalled(t TestingT, filters ...CallFilter) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	if j.Count(filters...) == 0 {
+		t.Errorf("mock: expected to be called, got none\n%s", j.dump())
+		return false
+	}
+	return true
+}
+
+// AssertCalledWithHeader 断言满足filters的请求中至少一次携带请求头name，value为空时只要求存在
+func (j *Journal) AssertCalledWithHeader(t TestingT, name, value string, filters ...CallFilter) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	if j.Count(append(append([]CallFilter(nil), filters...), CallWithHeader(name, value))...) == 0 {
+		t.Errorf("mock: expected to be called with header %s: %q, got none\n%s", name, value, j.dump())
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled 断言没有满足filters的请求
+func (j *Journal) AssertNotCalled(t TestingT, filters ...CallFilter) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	calls := j.Calls(filters...)
+	if len(calls) > 0 {
+		t.Errorf("mock: expected never called, got %d calls\n%s", len(calls), dumpCalls(calls))
+		return false
+	}
+	return true
+}
+
+// AssertAllMatched 断言所有请求均匹配了规则，否则报告未匹配的请求
+func (j *Journal) AssertAllMatched(t TestingT) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	unmatched := j.Unmatched()
+	if len(unmatched) > 0 {
+		t.Errorf("mock: %d requests matched no rule\n%s", len(unmatched), dumpCalls(unmatched))
+		return false
+	}
+	return true
+}
+
+func (j *Journal) dump() string {
+	return dumpCalls(j.Calls())
+}
+
+func dumpCalls(calls []*Call) string {
+	if len(calls) == 0 {
+		return "recorded calls: none"
+	}
+	lines := []string{"recorded calls:"}
+	for i, call := range calls {
+		lines = append(lines, fmt.Sprintf("  %d. %s", i+1, call))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func matchCall(call *Call, filters []CallFilter) bool {
+	for _, filter := range filters {
+		if !filter(call) {
+			return false
+		}
+	}
+	return true
+}
+
+// JournalMatcher 包装Matcher，将经过的每个请求记录到Journal，可用于Transport和Handler
+type JournalMatcher struct {
+	Matcher Matcher
+	Journal *Journal
+}
+
+// NewJournalMatcher 新建JournalMatcher，journal为nil时新建
+func NewJournalMatcher(matcher Matcher, journal *Journal) *JournalMatcher {
+	if journal == nil {
+		journal = NewJournal()
+	}
+	return &JournalMatcher{Matcher: matcher, Journal: journal}
+}
+
+// Match 调用被包装的Matcher并记录请求，Matcher实现了RuleSelector时同时记录匹配的规则名
+func (m *JournalMatcher) Match(r *http.Request) (string, ResponseMocker, error) {
+	// NOTE: 先读取Body，计算特征值可能执行ParseForm消耗Body
+	body, err := utils.ReadAndRestoreBody(r)
+	if err != nil {
+		return "", nil, err
+	}
+	call := &Call{
+		Time:   time.Now(),
+		Method: r.Method,
+		URL:    r.URL.String(),
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+	var (
+		key    string
+		mocker ResponseMocker
+	)
+	if rm, ok := m.Matcher.(RuleSelector); ok {
+		var rule *Rule
+		key, rule, err = rm.MatchRule(r)
+		if rule != nil {
+			call.Rule, mocker = rule.Name, rule.Mocker
+		}
+	} else {
+		key, mocker, err = m.Matcher.Match(r)
+	}
+	call.Eigenkey, call.Matched = key, mocker != nil
+	if err != nil {
+		call.Error = err.Error()
+	}
+	m.Journal.Record(call)
+	return key, mocker, err
+}
+
+// Eigenkey 计算请求特征值
+func (m *JournalMatcher) Eigenkey(r *http.Request) (string, error) {
+	return m.Matcher.Eigenkey(r)
+}
+
+var (
+	_ Matcher = (*JournalMatcher)(nil)
+)
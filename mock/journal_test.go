@@ -0,0 +1,108 @@
+package mock_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestJournalMatcher(t *testing.T) {
+	rules, err := mock.LoadRules([]byte(testRulesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := &mock.RuleMatcher{Rules: rules}
+	err = matcher.Provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm := mock.NewJournalMatcher(matcher, nil)
+	client := &http.Client{Transport: mock.NewTransport(jm, nil)}
+
+	for i := 0; i < 2; i++ {
+		rq, _ := http.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{"user":{"level":1}}`))
+		rq.Header.Set("X-Tenant", "acme")
+		rp, err := client.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rp.Body)
+		if string(body) != "normal" {
+			t.Fatalf("got %s", body)
+		}
+	}
+
+	journal := jm.Journal
+	if !journal.AssertCalledTimes(t, 2, mock.CallWithRule("order")) ||
+		!journal.AssertCalledWithHeader(t, "x-tenant", "acme", mock.CallWithRule("order")) ||
+		!journal.AssertNotCalled(t, mock.CallWithRule("vip-order")) ||
+		!journal.AssertAllMatched(t) {
+		t.FailNow()
+	}
+	call := journal.Calls()[0]
+	if call.Method != "POST" || call.Path != "/orders" || string(call.Body) != `{"user":{"level":1}}` || call.Eigenkey == "" || call.Time.IsZero() {
+		t.Fatalf("got call %+v", call)
+	}
+
+	rq, _ := http.NewRequest("GET", "http://localhost/missing", nil)
+	_, _, err = jm.Match(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unmatched := journal.Unmatched(); len(unmatched) != 1 || unmatched[0].Path != "/missing" {
+		t.Fatalf("got unmatched %v", unmatched)
+	}
+
+	ft := &fakeT{}
+	if journal.AssertAllMatched(ft) || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "GET http://localhost/missing (unmatched)") {
+		t.Fatalf("should report unmatched requests, got %v", ft.errors)
+	}
+	ft = &fakeT{}
+	if journal.AssertCalledTimes(ft, 3, mock.CallWithMethod("post")) || journal.AssertNotCalled(ft, mock.CallWithPath("/orders")) ||
+		journal.AssertCalled(ft, mock.CallWithEigenkey("none")) || journal.AssertCalledWithHeader(ft, "X-Tenant", "other") {
+		t.Fatal("assertions should fail")
+	}
+	if len(ft.errors) != 4 {
+		t.Fatalf("got %d errors", len(ft.errors))
+	}
+
+	journal.Reset()
+	journal.AssertNotCalled(t)
+	// NOTE: 包装RuleMatcher的Matcher通过RuleSelector同样记录规则名
+	wrapped := mock.NewJournalMatcher(&wrappedMatcher{RuleMatcher: matcher}, nil)
+	rq, _ = http.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{}`))
+	if _, mocker, err := wrapped.Match(rq); err != nil || mocker == nil {
+		t.Fatal("should match", err)
+	}
+	if !wrapped.Journal.AssertCalledTimes(t, 1, mock.CallWithRule("order")) {
+		t.FailNow()
+	}
+}
+
+// wrappedMatcher 包装RuleMatcher的Matcher，具体类型不是*mock.RuleMatcher
+type wrappedMatcher struct {
+	*mock.RuleMatcher
+}
+
+func TestJournalLimit(t *testing.T) {
+	journal := &mock.Journal{Limit: 2}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		journal.Record(&mock.Call{Path: path})
+	}
+	calls := journal.Calls()
+	if len(calls) != 2 || calls[0].Path != "/b" || calls[1].Path != "/c" {
+		t.Fatalf("got %v", calls)
+	}
+}
@@ -0,0 +1,135 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/eigenkey"
+)
+
+// ErrNotProvisioned Matcher未调用Provision，Extractor为nil
+var ErrNotProvisioned = errors.New("matcher is not provisioned")
+
+// EigenkeyMatcher 内置Matcher实现，使用eigenkey.HTTPRequestEigenkeyExtractor计算请求特征值，然后在规则表中查找对应的ResponseMocker
+// Usage:
+// 1. 通过json配置，rules的每个值均使用UnmarshalResponseMocker解析;
+// 2. 使用前需调用Provision初始化
+type EigenkeyMatcher struct {
+	Extractor *eigenkey.HTTPRequestEigenkeyExtractor `json:"extractor"`
+	Rules     map[string]ResponseMocker              `json:"-"`
+
+	lock sync.RWMutex
+}
+
+// NewEigenkeyMatcher 新建EigenkeyMatcher，extractor可为nil，Provision时使用默认配置(仅使用path)
+func NewEigenkeyMatcher(extractor *eigenkey.HTTPRequestEigenkeyExtractor) *EigenkeyMatcher {
+	return &EigenkeyMatcher{
+		Extractor: extractor,
+		Rules:     make(map[string]ResponseMocker),
+	}
+}
+
+// UnmarshalJSON 解析json配置，其中rules的每个值均使用UnmarshalResponseMocker解析
+func (m *EigenkeyMatcher) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Extractor *eigenkey.HTTPRequestEigenkeyExtractor `json:"extractor"`
+		Rules     map[string]json.RawMessage             `json:"rules"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return errors.WithMessage(err, "unmarshal eigenkey matcher failed")
+	}
+	rules := make(map[string]ResponseMocker, len(raw.Rules))
+	for key, rule := range raw.Rules {
+		mocker, err := UnmarshalResponseMocker(rule)
+		if err != nil {
+			return errors.WithMessagef(err, "unmarshal rule for eigenkey %s failed", key)
+		}
+		rules[key] = mocker
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Extractor = raw.Extractor
+	m.Rules = rules
+	return nil
+}
+
+// MarshalJSON 序列化json配置
+func (m *EigenkeyMatcher) MarshalJSON() ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	rules := make(map[string]json.RawMessage, len(m.Rules))
+	for key, mocker := range m.Rules {
+		data, err := MarshalResponseMocker(mocker)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "marshal rule for eigenkey %s failed", key)
+		}
+		rules[key] = data
+	}
+	return json.Marshal(map[string]interface{}{
+		"extractor": m.Extractor,
+		"rules":     rules,
+	})
+}
+
+// Provision 初始化，Extractor为nil时使用默认配置(仅使用path)
+func (m *EigenkeyMatcher) Provision() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.Extractor == nil {
+		m.Extractor = &eigenkey.HTTPRequestEigenkeyExtractor{}
+	}
+	if m.Rules == nil {
+		m.Rules = make(map[string]ResponseMocker)
+	}
+	return m.Extractor.Provision()
+}
+
+// Eigenkey 计算请求特征值，未调用Provision时返回ErrNotProvisioned
+func (m *EigenkeyMatcher) Eigenkey(r *http.Request) (string, error) {
+	if m.Extractor == nil {
+		return "", ErrNotProvisioned
+	}
+	return m.Extractor.Eigenkey(r)
+}
+
+// NeedBody 实现BodyMatcher，Extractor计算特征值需要读取Body时返回true
+func (m *EigenkeyMatcher) NeedBody(r *http.Request) bool {
+	return m.Extractor != nil && m.Extractor.NeedBody(r)
+}
+
+// Match 计算请求特征值并查找对应的ResponseMocker，未匹配时ResponseMocker返回nil
+func (m *EigenkeyMatcher) Match(r *http.Request) (string, ResponseMocker, error) {
+	key, err := m.Eigenkey(r)
+	if err != nil {
+		return "", nil, errors.WithMessage(err, "compute eigenkey failed")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return key, m.Rules[key], nil
+}
+
+// Set 设置特征值对应的ResponseMocker，并发安全
+func (m *EigenkeyMatcher) Set(key string, mocker ResponseMocker) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.Rules == nil {
+		m.Rules = make(map[string]ResponseMocker)
+	}
+	m.Rules[key] = mocker
+}
+
+// Delete 删除特征值对应的ResponseMocker，并发安全
+func (m *EigenkeyMatcher) Delete(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.Rules, key)
+}
+
+var (
+	_ Matcher     = (*EigenkeyMatcher)(nil)
+	_ BodyMatcher = (*EigenkeyMatcher)(nil)
+)
@@ -0,0 +1,119 @@
+package mock_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestEigenkeyMatcher(t *testing.T) {
+	data := []byte(`{
+		"extractor": {
+			"request_extractor": {
+				"use_method": true,
+				"use_path": true
+			},
+			"clean_path": true
+		},
+		"rules": {
+			"GET:/users": {
+				"response_mocker": "ResponseMockerBuilder",
+				"status_code": 200,
+				"body": "users"
+			},
+			"POST:/users": {}
+		}
+	}`)
+	var matcher mock.EigenkeyMatcher
+	err := json.Unmarshal(data, &matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = matcher.Provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rq, _ := http.NewRequest("GET", "http://localhost//users", nil)
+	key, mocker, err := matcher.Match(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "GET:/users" {
+		t.Fatalf("should ==, got %s", key)
+	}
+	if mocker == nil || mocker.IsTransparent() {
+		t.Fatal("should match builder")
+	}
+	rp, err := mocker.Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "users" {
+		t.Fatalf("should ==, got %s", body)
+	}
+
+	rq, _ = http.NewRequest("POST", "http://localhost/users", http.NoBody)
+	_, mocker, err = matcher.Match(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mocker == nil || !mocker.IsTransparent() {
+		t.Fatal("should match transparent")
+	}
+
+	rq, _ = http.NewRequest("DELETE", "http://localhost/users", nil)
+	key, mocker, err = matcher.Match(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "DELETE:/users" || mocker != nil {
+		t.Fatalf("should not match, got %s", key)
+	}
+
+	matcher.Set("DELETE:/users", new(mock.TransparentResponseMocker))
+	_, mocker, _ = matcher.Match(rq)
+	if mocker == nil {
+		t.Fatal("should match after set")
+	}
+	matcher.Delete("DELETE:/users")
+	_, mocker, _ = matcher.Match(rq)
+	if mocker != nil {
+		t.Fatal("should not match after delete")
+	}
+
+	out, err := json.Marshal(&matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again mock.EigenkeyMatcher
+	err = json.Unmarshal(out, &again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Rules) != 2 || again.Rules["GET:/users"].ID() != "ResponseMockerBuilder" {
+		t.Fatalf("marshal round trip failed: %s", out)
+	}
+}
+
+func TestEigenkeyMatcherNotProvisioned(t *testing.T) {
+	rq, _ := http.NewRequest("GET", "http://localhost/a", nil)
+	_, _, err := (&mock.EigenkeyMatcher{}).Match(rq)
+	if !errors.Is(err, mock.ErrNotProvisioned) {
+		t.Fatal("nil extractor should fail", err)
+	}
+	_, _, err = (&mock.RuleMatcher{}).Match(rq)
+	if !errors.Is(err, mock.ErrNotProvisioned) {
+		t.Fatal("nil extractor should fail", err)
+	}
+	_, _, err = mock.NewEigenkeyMatcher(&eigenkey.HTTPRequestEigenkeyExtractor{}).Match(rq)
+	if !errors.Is(err, eigenkey.ErrNotProvisioned) {
+		t.Fatal("unprovisioned extractor should fail", err)
+	}
+}
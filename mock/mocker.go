@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"text/template"
 
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
@@ -26,6 +27,11 @@ type Matcher interface {
 	Eigenkey(*http.Request) (string, error)
 }
 
+// BodyMatcher 可选接口，Matcher实现后Transport和Handler仅在NeedBody返回true时缓存请求Body用于匹配，未实现时总是缓存
+type BodyMatcher interface {
+	NeedBody(*http.Request) bool
+}
+
 // ResponseMocker 定义生成mock response的工具类接口
 // Usage：
 // 1. 三方扩展mocker需要将ID和自身实例注册到MetaOfResponseMocker资源;
@@ -53,9 +59,52 @@ type ResponseMocker interface {
 	Extension() *Options
 }
 
-// Option 描述一些公共行为，如latency
+// Delegator 可选接口，由组合类ResponseMocker实现，根据请求选择实际使用的ResponseMocker(可以是透明Mocker)
+// NOTE: Transport和Handler在判断IsTransparent之前会调用Resolve解析Delegator
+type Delegator interface {
+	Delegate(*http.Request) (ResponseMocker, error)
+}
+
+// Resolve 递归解析Delegator，返回实际使用的ResponseMocker及其Options，被选中的ResponseMocker未指定Options时沿用上层的Options
+func Resolve(mocker ResponseMocker, r *http.Request) (ResponseMocker, *Options, error) {
+	options := mocker.Extension()
+	for {
+		delegator, ok := mocker.(Delegator)
+		if !ok {
+			return mocker, options, nil
+		}
+		selected, err := delegator.Delegate(r)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "delegate response mocker %s failed", mocker.ID())
+		}
+		if selected == nil {
+			return nil, nil, errors.Errorf("response mocker %s delegated to nil", mocker.ID())
+		}
+		mocker = selected
+		if mocker.Extension() != nil {
+			options = mocker.Extension()
+		}
+	}
+}
+
+// Option 描述一些公共行为，如latency以及故障注入，Transport和Handler均遵循这些设置
 type Options struct {
-	Latency utils.Duration `json:"latency"`
+	Latency             utils.Duration       `json:"latency"`
+	LatencyDistribution *LatencyDistribution `json:"latency_distribution,omitempty"` // NOTE: 在Latency基础上叠加的随机延迟
+	ErrorRate           float32              `json:"error_rate,omitempty"`           // NOTE: 返回错误状态码的概率，[0, 1]
+	ErrorStatusCode     int                  `json:"error_status_code,omitempty"`    // NOTE: 错误状态码，默认500
+	AbortRate           float32              `json:"abort_rate,omitempty"`           // NOTE: 模拟连接重置的概率，[0, 1]
+	TruncateRate        float32              `json:"truncate_rate,omitempty"`        // NOTE: 截断响应Body的概率，[0, 1]
+	TruncateBytes       int                  `json:"truncate_bytes,omitempty"`       // NOTE: 截断时保留的字节数
+	BytesPerSecond      int                  `json:"bytes_per_second,omitempty"`     // NOTE: 响应Body限速，0表示不限速
+}
+
+// Wait 按Latency及LatencyDistribution延迟，如果ctx先结束则返回ctx.Err()，nil Options不延迟
+func (o *Options) Wait(ctx context.Context) error {
+	if o == nil {
+		return nil
+	}
+	return sleep(ctx, o.Latency.Duration+o.LatencyDistribution.Sample())
 }
 
 func UnmarshalResponseMocker(jsonBytes []byte) (ResponseMocker, error) {
@@ -75,6 +124,25 @@ func UnmarshalResponseMocker(jsonBytes []byte) (ResponseMocker, error) {
 	return mocker, nil
 }
 
+// MarshalResponseMocker 序列化ResponseMocker，结果额外包含`"response_mocker": "ID"`字段，可被UnmarshalResponseMocker还原
+func MarshalResponseMocker(mocker ResponseMocker) ([]byte, error) {
+	data, err := json.Marshal(mocker)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "marshal response mocker %s failed", mocker.ID())
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "response mocker %s should be marshaled as json object", mocker.ID())
+	}
+	id, err := json.Marshal(mocker.ID())
+	if err != nil {
+		return nil, err
+	}
+	fields["response_mocker"] = id
+	return json.Marshal(fields)
+}
+
 // TransparentResponseMocker 透明Mocker，即从源服务获取真实响应作为Mock，默认如果不指定则使用此Mocker
 type TransparentResponseMocker struct {
 	*Options `json:"options"`
@@ -103,7 +171,8 @@ func (mr TransparentResponseMocker) Extension() *Options {
 // ResponseMockerFromURL 请求URL的获取整个响应作为Mock，通常用于Mock平台
 type ResponseMockerFromURL struct {
 	*Options        `json:"options"`
-	ResponseFromURL string `json:"response_from_url"`
+	ResponseFromURL string        `json:"response_from_url"`
+	Fetch           *FetchOptions `json:"fetch,omitempty"` // NOTE: 客户端、超时、重试及缓存设置，请求使用传入请求的context
 }
 
 func (mr ResponseMockerFromURL) ID() string {
@@ -119,11 +188,11 @@ func (mr ResponseMockerFromURL) IsTransparent() bool {
 }
 
 func (mr ResponseMockerFromURL) Mock(r *http.Request) (*http.Response, error) {
-	rp, err := http.Get(mr.ResponseFromURL)
+	fr, err := mr.Fetch.Fetch(r.Context(), mr.ResponseFromURL)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "get mock response from %s failed", mr.ResponseFromURL)
 	}
-	return rp, nil
+	return fr.Response(), nil
 }
 
 func (mr ResponseMockerFromURL) Extension() *Options {
@@ -132,11 +201,18 @@ func (mr ResponseMockerFromURL) Extension() *Options {
 
 // ResponseMockerBuilder Response Mock构造器，通过指定状态码、头和Body生成Mock响应，通常用于静态mock
 type ResponseMockerBuilder struct {
-	*Options    `json:"options"`
-	StatusCode  int         `json:"status_code"`
-	Header      http.Header `json:"header,omitempty"`
-	Body        string      `json:"body,omitempty"`          // NOTE: 与BodyFromURL二选一即可，都存在以Body为主
-	BodyFromURL string      `json:"body_from_url,omitempty"` // NOTE: 根据请求URL结果作为响应Body，如OSS场景
+	*Options     `json:"options"`
+	StatusCode   int           `json:"status_code"`
+	Header       http.Header   `json:"header,omitempty"`
+	Body         string        `json:"body,omitempty"`           // NOTE: 与BodyFromFile、BodyFromURL三选一即可，优先级Body > BodyFromFile > BodyFromURL
+	BodyFromFile string        `json:"body_from_file,omitempty"` // NOTE: 使用Fixtures指定的FixtureLoader加载文件作为响应Body，并根据扩展名推断Content-Type
+	Fixtures     string        `json:"fixtures,omitempty"`       // NOTE: FixtureLoader的注册名，默认为本地文件系统
+	BodyFromURL  string        `json:"body_from_url,omitempty"`  // NOTE: 根据请求URL结果作为响应Body，如OSS场景
+	Fetch        *FetchOptions `json:"fetch,omitempty"`          // NOTE: BodyFromURL的客户端、超时、重试及缓存设置
+	Template     bool          `json:"template,omitempty"`       // NOTE: 为true时Body和Header的值作为text/template渲染，数据为TemplateData
+	Stream       *Stream       `json:"stream,omitempty"`         // NOTE: 流式响应，指定时忽略Body、BodyFromFile和BodyFromURL
+
+	templates map[string]*template.Template // NOTE: UnmarshalJSON时预先解析的模板，key为模板文本
 }
 
 func (mr ResponseMockerBuilder) ID() string {
@@ -156,21 +232,61 @@ func (mr ResponseMockerBuilder) Mock(r *http.Request) (*http.Response, error) {
 	if header == nil {
 		header = http.Header{}
 	}
+	var data *TemplateData
+	if mr.Template {
+		var err error
+		data, err = NewTemplateData(r)
+		if err != nil {
+			return nil, err
+		}
+		header, err = mr.renderHeader(header, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mr.Stream != nil {
+		return mr.mockStream(r, header, data)
+	}
 	var body io.ReadCloser
-	if mr.Body != "" {
-		body = NewResponseBodyFromString(mr.Body)
-	} else {
-		if mr.BodyFromURL != "" {
-			rp, err := http.Get(mr.BodyFromURL)
+	switch {
+	case mr.Body != "":
+		if data != nil {
+			rendered, err := mr.render(mr.Body, data)
 			if err != nil {
-				return nil, errors.WithMessagef(err, "get mock response body from %s failed", mr.BodyFromURL)
+				return nil, errors.WithMessage(err, "render mock response body failed")
 			}
-			rawBody, err := ioutil.ReadAll(rp.Body)
+			body = NewResponseBodyFromString(rendered)
+		} else {
+			body = NewResponseBodyFromString(mr.Body)
+		}
+	case mr.BodyFromFile != "":
+		loader, err := GetFixtureLoader(mr.Fixtures)
+		if err != nil {
+			return nil, err
+		}
+		fixture, err := loader.Load(mr.BodyFromFile)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			rendered, err := mr.render(string(fixture.Body), data)
 			if err != nil {
-				return nil, errors.WithMessagef(err, "read mock response body from %s failed", mr.BodyFromURL)
+				return nil, errors.WithMessagef(err, "render mock response body from %s failed", mr.BodyFromFile)
 			}
-			body = NewResponseBodyFromBytes(rawBody)
+			body = NewResponseBodyFromString(rendered)
+		} else {
+			body = NewResponseBodyFromBytes(fixture.Body)
 		}
+		if fixture.ContentType != "" && header.Get("Content-Type") == "" {
+			header = header.Clone()
+			header.Set("Content-Type", fixture.ContentType)
+		}
+	case mr.BodyFromURL != "":
+		fr, err := mr.Fetch.Fetch(r.Context(), mr.BodyFromURL)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "get mock response body from %s failed", mr.BodyFromURL)
+		}
+		body = NewResponseBodyFromBytes(fr.Body)
 	}
 	rp := &http.Response{
 		Status:        strconv.Itoa(mr.StatusCode),
@@ -186,6 +302,33 @@ func (mr ResponseMockerBuilder) Extension() *Options {
 	return mr.Options
 }
 
+// mockStream 生成流式响应，TransferEncoding为chunked，Handler据此逐块flush
+func (mr ResponseMockerBuilder) mockStream(r *http.Request, header http.Header, data *TemplateData) (*http.Response, error) {
+	var render func(string) (string, error)
+	if data != nil {
+		render = func(s string) (string, error) {
+			return mr.render(s, data)
+		}
+	}
+	chunks, err := mr.Stream.Encode(render)
+	if err != nil {
+		return nil, errors.WithMessage(err, "encode mock response stream failed")
+	}
+	header = header.Clone()
+	if contentType := mr.Stream.ContentType(); contentType != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", contentType)
+		header.Set("Cache-Control", "no-cache")
+	}
+	return &http.Response{
+		Status:           strconv.Itoa(mr.StatusCode),
+		StatusCode:       mr.StatusCode,
+		Body:             mr.Stream.Body(r.Context(), chunks),
+		Header:           header,
+		ContentLength:    -1,
+		TransferEncoding: []string{"chunked"},
+	}, nil
+}
+
 func NewResponseBodyFromString(body string) io.ReadCloser {
 	return ioutil.NopCloser(bytes.NewBufferString(body))
 }
@@ -0,0 +1,220 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// MultiResponseMocker 选择模式
+const (
+	SelectWeighted   = "weighted"    // 按权重随机选择
+	SelectRoundRobin = "round_robin" // 轮询
+	SelectSequence   = "sequence"    // 严格按顺序，每个子Mocker使用times次，最后一个一直使用，如"先500一次再200"
+	SelectSticky     = "sticky"      // 同一请求特征值首次按权重随机选择，之后固定
+)
+
+// MaxStickyKeys sticky模式记住的请求特征值个数上限，超过时淘汰最早记住的特征值
+var MaxStickyKeys = 10000
+
+// MultiResponseMocker 组合Mocker，持有一组子ResponseMocker，每次调用按Mode选择其一，用于模拟不稳定或逐渐恢复的上游
+// Usage:
+// 1. 子Mocker同样使用`"response_mocker": "ID"`指定类型，可以嵌套;
+// 2. 子Mocker可以是TransparentResponseMocker，Transport和Handler通过Delegator接口在判断是否透明前完成选择;
+// 3. 子Mocker未指定options时沿用MultiResponseMocker的options
+type MultiResponseMocker struct {
+	*Options `json:"options"`
+	Mode     string                `json:"mode"`
+	Mockers  []*MultiResponseEntry `json:"mockers"`
+
+	lock       sync.Mutex
+	calls      int
+	sticky     map[string]int
+	stickyKeys []string // NOTE: 按记住的先后顺序，用于淘汰
+}
+
+// MultiResponseEntry MultiResponseMocker的子Mocker
+type MultiResponseEntry struct {
+	Weight float32        `json:"weight,omitempty"` // NOTE: weighted和sticky模式使用，全部为0时等权重
+	Times  int            `json:"times,omitempty"`  // NOTE: sequence模式使用，默认1
+	Mocker ResponseMocker `json:"-"`
+}
+
+// UnmarshalJSON 使用UnmarshalResponseMocker解析mocker字段
+func (e *MultiResponseEntry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Weight float32         `json:"weight"`
+		Times  int             `json:"times"`
+		Mocker json.RawMessage `json:"mocker"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bytes.TrimSpace(raw.Mocker), []byte("null")) {
+		return errors.New("mocker should not be null")
+	}
+	mocker, err := UnmarshalResponseMocker(raw.Mocker)
+	if err != nil {
+		return err
+	}
+	e.Weight, e.Times, e.Mocker = raw.Weight, raw.Times, mocker
+	return nil
+}
+
+// MarshalJSON 使用MarshalResponseMocker序列化mocker字段
+func (e *MultiResponseEntry) MarshalJSON() ([]byte, error) {
+	mocker, err := MarshalResponseMocker(e.Mocker)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"weight": e.Weight,
+		"times":  e.Times,
+		"mocker": json.RawMessage(mocker),
+	})
+}
+
+// UnmarshalJSON 解析并校验mode和子Mocker，配置错误在加载时报错而不是在请求时
+// NOTE: `null`元素不会调用MultiResponseEntry.UnmarshalJSON，需在此拒绝
+func (mr *MultiResponseMocker) UnmarshalJSON(data []byte) error {
+	type plain MultiResponseMocker
+	err := json.Unmarshal(data, (*plain)(mr))
+	if err != nil {
+		return err
+	}
+	switch mr.Mode {
+	case "", SelectWeighted, SelectRoundRobin, SelectSequence, SelectSticky:
+	default:
+		return errors.Errorf("%s has invalid mode %s", mr.ID(), mr.Mode)
+	}
+	if len(mr.Mockers) == 0 {
+		return errors.Errorf("%s has no mockers", mr.ID())
+	}
+	for i, e := range mr.Mockers {
+		if e == nil || e.Mocker == nil {
+			return errors.Errorf("%s mockers[%d] should not be null", mr.ID(), i)
+		}
+		if e.Weight < 0 {
+			return errors.Errorf("%s mockers[%d] has negative weight %v", mr.ID(), i, e.Weight)
+		}
+	}
+	return nil
+}
+
+func (mr *MultiResponseMocker) ID() string {
+	return "MultiResponseMocker"
+}
+
+func (mr *MultiResponseMocker) New() ResponseMocker {
+	return new(MultiResponseMocker)
+}
+
+func (mr *MultiResponseMocker) IsTransparent() bool {
+	return false
+}
+
+// Mock 选择子Mocker生成响应，选中透明Mocker时返回错误，因此通常应通过Transport或Handler使用
+func (mr *MultiResponseMocker) Mock(r *http.Request) (*http.Response, error) {
+	mocker, _, err := Resolve(mr, r)
+	if err != nil {
+		return nil, err
+	}
+	if mocker.IsTransparent() {
+		return nil, errors.Errorf("%s selected a transparent response mocker", mr.ID())
+	}
+	return mocker.Mock(r)
+}
+
+func (mr *MultiResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// Delegate 实现Delegator，按Mode选择子Mocker
+func (mr *MultiResponseMocker) Delegate(r *http.Request) (ResponseMocker, error) {
+	if len(mr.Mockers) == 0 {
+		return nil, errors.Errorf("%s has no mockers", mr.ID())
+	}
+	mr.lock.Lock()
+	defer mr.lock.Unlock()
+	var i int
+	switch mr.Mode {
+	case SelectWeighted, "":
+		i = mr.weightedChoice()
+	case SelectRoundRobin:
+		i = mr.calls % len(mr.Mockers)
+	case SelectSequence:
+		i = mr.sequenceIndex()
+	case SelectSticky:
+		key, _ := EigenkeyFromContext(r.Context())
+		var ok bool
+		i, ok = mr.sticky[key]
+		if !ok {
+			i = mr.weightedChoice()
+			mr.stick(key, i)
+		}
+	default:
+		return nil, errors.Errorf("%s has invalid mode %s", mr.ID(), mr.Mode)
+	}
+	mr.calls++
+	return mr.Mockers[i].Mocker, nil
+}
+
+// Reset 重置调用计数和sticky状态
+func (mr *MultiResponseMocker) Reset() {
+	mr.lock.Lock()
+	defer mr.lock.Unlock()
+	mr.calls = 0
+	mr.sticky = nil
+	mr.stickyKeys = nil
+}
+
+func (mr *MultiResponseMocker) stick(key string, i int) {
+	if mr.sticky == nil {
+		mr.sticky = make(map[string]int)
+	}
+	for MaxStickyKeys > 0 && len(mr.stickyKeys) >= MaxStickyKeys {
+		delete(mr.sticky, mr.stickyKeys[0])
+		mr.stickyKeys = mr.stickyKeys[1:]
+	}
+	mr.sticky[key] = i
+	mr.stickyKeys = append(mr.stickyKeys, key)
+}
+
+func (mr *MultiResponseMocker) weightedChoice() int {
+	weights := make([]float32, len(mr.Mockers))
+	var sum float32
+	for i, e := range mr.Mockers {
+		weights[i] = e.Weight
+		sum += e.Weight
+	}
+	if sum <= 0 {
+		return utils.Random(0, len(mr.Mockers))
+	}
+	return utils.WeightedChoice(weights)
+}
+
+func (mr *MultiResponseMocker) sequenceIndex() int {
+	n := mr.calls
+	for i, e := range mr.Mockers {
+		times := e.Times
+		if times <= 0 {
+			times = 1
+		}
+		if n < times {
+			return i
+		}
+		n -= times
+	}
+	return len(mr.Mockers) - 1
+}
+
+var (
+	_ ResponseMocker = (*MultiResponseMocker)(nil)
+	_ Delegator      = (*MultiResponseMocker)(nil)
+)
@@ -0,0 +1,149 @@
+package mock_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func newMultiMocker(t *testing.T, mode string) mock.ResponseMocker {
+	mocker, err := mock.UnmarshalResponseMocker([]byte(`{
+		"response_mocker": "MultiResponseMocker",
+		"mode": "` + mode + `",
+		"mockers": [
+			{"weight": 1, "times": 2, "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 500}},
+			{"weight": 1, "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 200}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mocker
+}
+
+func TestMultiResponseMocker(t *testing.T) {
+	statusCodes := func(mocker mock.ResponseMocker, path string, n int) []int {
+		var codes []int
+		for i := 0; i < n; i++ {
+			rq, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+			rq = rq.WithContext(mock.WithEigenkey(rq.Context(), path))
+			rp, err := mocker.Mock(rq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes = append(codes, rp.StatusCode)
+		}
+		return codes
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	codes := statusCodes(newMultiMocker(t, mock.SelectSequence), "/", 4)
+	if !equal(codes, []int{500, 500, 200, 200}) {
+		t.Fatalf("sequence got %v", codes)
+	}
+	codes = statusCodes(newMultiMocker(t, mock.SelectRoundRobin), "/", 4)
+	if !equal(codes, []int{500, 200, 500, 200}) {
+		t.Fatalf("round robin got %v", codes)
+	}
+	mocker := newMultiMocker(t, mock.SelectSticky)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		codes = statusCodes(mocker, path, 5)
+		for _, code := range codes {
+			if code != codes[0] {
+				t.Fatalf("sticky got %v", codes)
+			}
+		}
+	}
+	codes = statusCodes(newMultiMocker(t, mock.SelectWeighted), "/", 100)
+	var n500 int
+	for _, code := range codes {
+		if code == 500 {
+			n500++
+		}
+	}
+	if n500 == 0 || n500 == 100 {
+		t.Fatalf("weighted should select both, got %d", n500)
+	}
+
+	mocker.(*mock.MultiResponseMocker).Reset()
+	_, err := mock.MarshalResponseMocker(mocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: sticky超过MaxStickyKeys时淘汰最早的特征值
+	defer func(n int) { mock.MaxStickyKeys = n }(mock.MaxStickyKeys)
+	mock.MaxStickyKeys = 2
+	multi := newMultiMocker(t, mock.SelectSticky).(*mock.MultiResponseMocker)
+	multi.Mockers[1].Weight = 0
+	if codes = statusCodes(multi, "/a", 1); codes[0] != 500 {
+		t.Fatalf("sticky got %v", codes)
+	}
+	multi.Mockers[0].Weight, multi.Mockers[1].Weight = 0, 1
+	if codes = statusCodes(multi, "/a", 1); codes[0] != 500 {
+		t.Fatalf("/a should be sticky, got %v", codes)
+	}
+	statusCodes(multi, "/b", 1)
+	statusCodes(multi, "/c", 1)
+	if codes = statusCodes(multi, "/a", 1); codes[0] != 200 {
+		t.Fatalf("/a should be evicted, got %v", codes)
+	}
+
+	for _, data := range []string{
+		`{"response_mocker": "MultiResponseMocker", "mockers": [null]}`,
+		`{"response_mocker": "MultiResponseMocker", "mockers": [{"mocker": null}]}`,
+		`{"response_mocker": "MultiResponseMocker", "mockers": []}`,
+		`{"response_mocker": "MultiResponseMocker"}`,
+		`{"response_mocker": "MultiResponseMocker", "mode": "random", "mockers": [{"mocker": {}}]}`,
+		`{"response_mocker": "MultiResponseMocker", "mockers": [{"weight": -1, "mocker": {}}]}`,
+	} {
+		_, err = mock.UnmarshalResponseMocker([]byte(data))
+		if err == nil {
+			t.Fatalf("%s should be rejected", data)
+		}
+	}
+}
+
+func TestMultiResponseMockerTransparent(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "recovered")
+	}))
+	defer upstream.Close()
+	matcher := newEigenkeyMatcher(t, nil, map[string]string{"/": `{
+		"response_mocker": "MultiResponseMocker",
+		"mode": "sequence",
+		"mockers": [
+			{"mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 503}},
+			{"mocker": {}}
+		]
+	}`})
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	rp, err := client.Get(upstream.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != 503 {
+		t.Fatalf("should be 503, got %d", rp.StatusCode)
+	}
+	rp, err = client.Get(upstream.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "recovered" {
+		t.Fatalf("should be transparent, got %s", body)
+	}
+}
@@ -0,0 +1,677 @@
+package mock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/jsonschema"
+	"github.com/ccmonky/pkg/utils"
+)
+
+// maxSchemaDepth 根据schema生成示例时的最大嵌套深度，避免循环引用
+const maxSchemaDepth = 8
+
+// OpenAPIResponseMocker 根据OpenAPI 3规范生成Mock响应，无需为每个接口手写响应
+// Usage:
+// 1. 根据请求的path和method查找规范中的operation，path模板如`/users/{id}`，未找到时返回404，path存在但method不存在时返回405;
+// 2. 响应状态码默认为规范中最小的2xx，其次为default，可通过status_code指定;
+// 3. 响应Body依次使用媒体类型的example、examples中的第一个value，否则根据schema生成示例;
+// 4. validate_request为true时，按规范校验path、query、header参数和json请求Body，失败时返回400及校验错误
+type OpenAPIResponseMocker struct {
+	*Options        `json:"options"`
+	SpecFile        string          `json:"spec_file,omitempty"`        // NOTE: 规范文件路径，支持json和yaml
+	Spec            json.RawMessage `json:"spec,omitempty"`             // NOTE: 内联规范，优先于spec_file
+	StatusCode      int             `json:"status_code,omitempty"`      // NOTE: 使用的响应状态码
+	ContentType     string          `json:"content_type,omitempty"`     // NOTE: 使用的响应媒体类型，默认优先application/json
+	ValidateRequest bool            `json:"validate_request,omitempty"` // NOTE: 是否校验请求
+
+	// Validate 校验请求使用的jsonschema校验函数，为nil时使用jsonschema.DefaultValidate
+	Validate jsonschema.ValidateFunc `json:"-"`
+
+	once sync.Once
+	doc  map[string]interface{}
+	err  error
+}
+
+func (mr *OpenAPIResponseMocker) ID() string {
+	return "OpenAPIResponseMocker"
+}
+
+func (mr *OpenAPIResponseMocker) New() ResponseMocker {
+	return new(OpenAPIResponseMocker)
+}
+
+func (mr *OpenAPIResponseMocker) IsTransparent() bool {
+	return false
+}
+
+// UnmarshalJSON 解析后立即加载规范，便于尽早发现配置错误
+func (mr *OpenAPIResponseMocker) UnmarshalJSON(data []byte) error {
+	type plain OpenAPIResponseMocker
+	err := json.Unmarshal(data, (*plain)(mr))
+	if err != nil {
+		return err
+	}
+	return mr.Load()
+}
+
+// Load 加载并缓存规范，仅执行一次，Mock时会自动调用
+func (mr *OpenAPIResponseMocker) Load() error {
+	mr.once.Do(func() {
+		data := []byte(mr.Spec)
+		if len(data) == 0 {
+			if mr.SpecFile == "" {
+				mr.err = errors.Errorf("%s requires spec or spec_file", mr.ID())
+				return
+			}
+			data, mr.err = ioutil.ReadFile(mr.SpecFile)
+			if mr.err != nil {
+				mr.err = errors.WithMessagef(mr.err, "read openapi spec %s failed", mr.SpecFile)
+				return
+			}
+		}
+		// NOTE: yaml是json的超集，统一转换为json
+		data, mr.err = yamlToJSON(data)
+		if mr.err != nil {
+			return
+		}
+		mr.err = json.Unmarshal(data, &mr.doc)
+		if mr.err != nil {
+			mr.err = errors.WithMessage(mr.err, "unmarshal openapi spec failed")
+			return
+		}
+		if _, ok := mr.doc["paths"].(map[string]interface{}); !ok {
+			mr.err = errors.New("openapi spec has no paths")
+		}
+	})
+	return mr.err
+}
+
+func (mr *OpenAPIResponseMocker) Mock(r *http.Request) (*http.Response, error) {
+	err := mr.Load()
+	if err != nil {
+		return nil, err
+	}
+	pathItem, pathParams, ok := mr.findPath(r.URL.Path)
+	if !ok {
+		return openAPIErrorResponse(http.StatusNotFound, "no operation matches "+r.URL.Path), nil
+	}
+	op := mr.object(pathItem[strings.ToLower(r.Method)])
+	if op == nil {
+		return openAPIErrorResponse(http.StatusMethodNotAllowed, "no operation matches "+r.Method+" "+r.URL.Path), nil
+	}
+	if mr.ValidateRequest {
+		failures, err := mr.validateRequest(r, pathItem, op, pathParams)
+		if err != nil {
+			return nil, err
+		}
+		if len(failures) > 0 {
+			return openAPIErrorResponse(http.StatusBadRequest, "request validation failed", failures...), nil
+		}
+	}
+	statusCode, response := mr.selectResponse(op)
+	if response == nil {
+		return nil, errors.Errorf("no response defined for %s %s", r.Method, r.URL.Path)
+	}
+	header := http.Header{}
+	for name, h := range mr.object(response["headers"]) {
+		value := mr.example(mr.object(h), 0)
+		if value == nil {
+			continue
+		}
+		header.Set(name, openAPIParamString(value))
+	}
+	var body []byte
+	contentType, media := mr.selectMedia(mr.object(response["content"]))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+		body, err = mr.exampleBody(contentType, media)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode),
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          NewResponseBodyFromBytes(body),
+		ContentLength: int64(len(body)),
+	}, nil
+}
+
+func (mr *OpenAPIResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// findPath 查找匹配的path模板，字面量段越多越优先，如`/users/me`优先于`/users/{id}`
+// NOTE: 未匹配时尝试去除servers中url的path前缀后再次匹配
+func (mr *OpenAPIResponseMocker) findPath(path string) (map[string]interface{}, map[string]string, bool) {
+	candidates := []string{path}
+	for _, server := range mr.array(mr.doc["servers"]) {
+		u, err := url.Parse(openAPIParamString(mr.object(server)["url"]))
+		if err != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(u.Path, "/")
+		if prefix != "" && strings.HasPrefix(path, prefix+"/") {
+			candidates = append(candidates, strings.TrimPrefix(path, prefix))
+		}
+	}
+	paths := mr.object(mr.doc["paths"])
+	templates := make([]string, 0, len(paths))
+	for template := range paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+	for _, candidate := range candidates {
+		var (
+			best       string
+			bestParams map[string]string
+			bestScore  = -1
+		)
+		segments := strings.Split(strings.Trim(candidate, "/"), "/")
+		for _, template := range templates {
+			params, score, ok := matchPathTemplate(strings.Split(strings.Trim(template, "/"), "/"), segments)
+			if ok && score > bestScore {
+				best, bestParams, bestScore = template, params, score
+			}
+		}
+		if bestScore >= 0 {
+			return mr.resolve(paths[best]), bestParams, true
+		}
+	}
+	return nil, nil, false
+}
+
+// matchPathTemplate 按段匹配path模板，返回path参数及字面量段数
+func matchPathTemplate(template, segments []string) (map[string]string, int, bool) {
+	if len(template) != len(segments) {
+		return nil, 0, false
+	}
+	params := make(map[string]string)
+	var score int
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil {
+				value = segments[i]
+			}
+			params[t[1:len(t)-1]] = value
+			continue
+		}
+		if t != segments[i] {
+			return nil, 0, false
+		}
+		score++
+	}
+	return params, score, true
+}
+
+// selectResponse 选择响应，优先status_code，其次最小的2xx，最后default
+func (mr *OpenAPIResponseMocker) selectResponse(op map[string]interface{}) (int, map[string]interface{}) {
+	responses := mr.object(op["responses"])
+	if mr.StatusCode > 0 {
+		if response, ok := responses[strconv.Itoa(mr.StatusCode)]; ok {
+			return mr.StatusCode, mr.resolve(response)
+		}
+		if response, ok := responses["default"]; ok {
+			return mr.StatusCode, mr.resolve(response)
+		}
+		return mr.StatusCode, nil
+	}
+	var codes []int
+	for code := range responses {
+		if c, err := strconv.Atoi(code); err == nil && c >= 200 && c < 300 {
+			codes = append(codes, c)
+		}
+	}
+	if len(codes) > 0 {
+		sort.Ints(codes)
+		return codes[0], mr.resolve(responses[strconv.Itoa(codes[0])])
+	}
+	if response, ok := responses["default"]; ok {
+		return http.StatusOK, mr.resolve(response)
+	}
+	return http.StatusOK, nil
+}
+
+// selectMedia 选择响应媒体类型，优先content_type，其次application/json，再次任意json类型，最后按字典序第一个
+func (mr *OpenAPIResponseMocker) selectMedia(content map[string]interface{}) (string, map[string]interface{}) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	if mr.ContentType != "" {
+		if media, ok := content[mr.ContentType]; ok {
+			return mr.ContentType, mr.object(media)
+		}
+	}
+	if media, ok := content["application/json"]; ok {
+		return "application/json", mr.object(media)
+	}
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if isJSONMediaType(t) {
+			return t, mr.object(content[t])
+		}
+	}
+	return types[0], mr.object(content[types[0]])
+}
+
+// exampleBody 根据媒体类型生成响应Body，非json类型的字符串示例原样返回
+func (mr *OpenAPIResponseMocker) exampleBody(contentType string, media map[string]interface{}) ([]byte, error) {
+	value := mr.example(media, 0)
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok && !isJSONMediaType(contentType) {
+		return []byte(s), nil
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshal openapi example failed")
+	}
+	return body, nil
+}
+
+// example 返回媒体类型、参数或响应头对象的示例：example、examples中的第一个value，否则根据schema生成
+func (mr *OpenAPIResponseMocker) example(obj map[string]interface{}, depth int) interface{} {
+	if obj == nil {
+		return nil
+	}
+	if example, ok := obj["example"]; ok {
+		return example
+	}
+	examples := mr.object(obj["examples"])
+	names := make([]string, 0, len(examples))
+	for name := range examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := mr.resolve(examples[name])["value"]; ok {
+			return value
+		}
+	}
+	schema := mr.resolve(obj["schema"])
+	if schema == nil {
+		return nil
+	}
+	return mr.generate(schema, depth)
+}
+
+// generate 根据schema生成示例
+func (mr *OpenAPIResponseMocker) generate(schema map[string]interface{}, depth int) interface{} {
+	schema = mr.resolve(schema)
+	if schema == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	for _, key := range []string{"example", "default"} {
+		if value, ok := schema[key]; ok {
+			return value
+		}
+	}
+	if enum := mr.array(schema["enum"]); len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf := mr.array(schema["allOf"]); len(allOf) > 0 {
+		merged := make(map[string]interface{})
+		for _, sub := range allOf {
+			if obj, ok := mr.generate(mr.object(sub), depth+1).(map[string]interface{}); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if subs := mr.array(schema[key]); len(subs) > 0 {
+			return mr.generate(mr.object(subs[0]), depth+1)
+		}
+	}
+	typ, _ := schema["type"].(string)
+	if typ == "" {
+		switch {
+		case schema["properties"] != nil:
+			typ = "object"
+		case schema["items"] != nil:
+			typ = "array"
+		}
+	}
+	switch typ {
+	case "object":
+		obj := make(map[string]interface{})
+		for name, prop := range mr.object(schema["properties"]) {
+			obj[name] = mr.generate(mr.object(prop), depth+1)
+		}
+		return obj
+	case "array":
+		item := mr.generate(mr.object(schema["items"]), depth+1)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case "integer", "number":
+		if minimum, ok := schema["minimum"].(float64); ok {
+			return minimum
+		}
+		return 0
+	case "boolean":
+		return false
+	case "string":
+		return exampleString(schema)
+	default:
+		return nil
+	}
+}
+
+// exampleString 根据format生成字符串示例
+func exampleString(schema map[string]interface{}) string {
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date-time":
+		return "1970-01-01T00:00:00Z"
+	case "date":
+		return "1970-01-01"
+	case "time":
+		return "00:00:00"
+	case "uuid":
+		return "00000000-0000-0000-0000-000000000000"
+	case "email":
+		return "user@example.com"
+	case "uri", "url":
+		return "https://example.com"
+	case "hostname":
+		return "example.com"
+	case "ipv4":
+		return "127.0.0.1"
+	case "ipv6":
+		return "::1"
+	case "byte":
+		return "c3RyaW5n"
+	}
+	s := "string"
+	if minLength, ok := schema["minLength"].(float64); ok && int(minLength) > len(s) {
+		s += strings.Repeat("x", int(minLength)-len(s))
+	}
+	return s
+}
+
+// validateRequest 按规范校验请求，返回所有校验失败信息
+func (mr *OpenAPIResponseMocker) validateRequest(r *http.Request, pathItem, op map[string]interface{}, pathParams map[string]string) ([]string, error) {
+	var failures []string
+	for _, param := range mr.parameters(pathItem, op) {
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		var (
+			values  []string
+			present bool
+		)
+		switch in {
+		case "path":
+			var value string
+			value, present = pathParams[name]
+			values = []string{value}
+		case "query":
+			values, present = r.URL.Query()[name]
+		case "header":
+			values, present = r.Header[http.CanonicalHeaderKey(name)]
+		case "cookie":
+			if cookie, err := r.Cookie(name); err == nil {
+				values, present = []string{cookie.Value}, true
+			}
+		default:
+			continue
+		}
+		required, _ := param["required"].(bool)
+		if !present {
+			if required || in == "path" {
+				failures = append(failures, "missing required "+in+" parameter "+name)
+			}
+			continue
+		}
+		schema := mr.resolve(param["schema"])
+		if schema == nil {
+			continue
+		}
+		value, ok := mr.coerceParam(schema, values)
+		if !ok {
+			failures = append(failures, in+" parameter "+name+" should be "+openAPIParamString(schema["type"]))
+			continue
+		}
+		failure, err := mr.validate(schema, value)
+		if err != nil {
+			return nil, err
+		}
+		if failure != "" {
+			failures = append(failures, in+" parameter "+name+": "+failure)
+		}
+	}
+	failure, err := mr.validateBody(r, mr.resolve(op["requestBody"]))
+	if err != nil {
+		return nil, err
+	}
+	if failure != "" {
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// validateBody 校验json请求Body，非json媒体类型仅校验是否必需
+func (mr *OpenAPIResponseMocker) validateBody(r *http.Request, requestBody map[string]interface{}) (string, error) {
+	if requestBody == nil {
+		return "", nil
+	}
+	body, err := utils.ReadAndRestoreBody(r)
+	if err != nil {
+		return "", err
+	}
+	if len(body) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			return "missing required request body", nil
+		}
+		return "", nil
+	}
+	content := mr.object(requestBody["content"])
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	media := mr.object(content[mediaType])
+	if media == nil && (mediaType == "" || isJSONMediaType(mediaType)) {
+		media, mediaType = mr.object(content["application/json"]), "application/json"
+	}
+	if media == nil {
+		if len(content) > 0 {
+			return "unsupported request content type " + r.Header.Get("Content-Type"), nil
+		}
+		return "", nil
+	}
+	schema := mr.resolve(media["schema"])
+	if schema == nil || !isJSONMediaType(mediaType) {
+		return "", nil
+	}
+	var value interface{}
+	err = json.Unmarshal(body, &value)
+	if err != nil {
+		return "request body is not valid json: " + err.Error(), nil
+	}
+	failure, err := mr.validate(schema, value)
+	if err != nil || failure == "" {
+		return "", err
+	}
+	return "request body: " + failure, nil
+}
+
+// validate 使用jsonschema校验value，schema中引用的components一并带入，返回校验失败信息
+func (mr *OpenAPIResponseMocker) validate(schema map[string]interface{}, value interface{}) (string, error) {
+	wrapped := map[string]interface{}{
+		"allOf": []interface{}{schema},
+	}
+	if components, ok := mr.doc["components"]; ok {
+		wrapped["components"] = components
+	}
+	schemaBytes, err := json.Marshal(wrapped)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	validate := mr.Validate
+	if validate == nil {
+		validate = jsonschema.DefaultValidate
+	}
+	err = validate(schemaBytes, data)
+	if err == nil {
+		return "", nil
+	}
+	if jsonschema.IsValidateFailedError(err) {
+		return err.Error(), nil
+	}
+	return "", errors.WithMessage(err, "validate request failed")
+}
+
+// parameters 合并path和operation的参数定义，operation中同名同位置的参数覆盖path中的
+func (mr *OpenAPIResponseMocker) parameters(pathItem, op map[string]interface{}) []map[string]interface{} {
+	var params []map[string]interface{}
+	index := make(map[string]int)
+	for _, raw := range append(mr.array(pathItem["parameters"]), mr.array(op["parameters"])...) {
+		param := mr.resolve(raw)
+		if param == nil {
+			continue
+		}
+		key := openAPIParamString(param["in"]) + ":" + openAPIParamString(param["name"])
+		if i, ok := index[key]; ok {
+			params[i] = param
+			continue
+		}
+		index[key] = len(params)
+		params = append(params, param)
+	}
+	return params
+}
+
+// coerceParam 按schema类型转换字符串参数值
+func (mr *OpenAPIResponseMocker) coerceParam(schema map[string]interface{}, values []string) (interface{}, bool) {
+	typ, _ := schema["type"].(string)
+	if typ == "array" {
+		items := mr.resolve(schema["items"])
+		if len(values) == 1 && strings.Contains(values[0], ",") {
+			values = strings.Split(values[0], ",")
+		}
+		arr := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			item, ok := coerceScalar(items, v)
+			if !ok {
+				return nil, false
+			}
+			arr = append(arr, item)
+		}
+		return arr, true
+	}
+	return coerceScalar(schema, values[0])
+}
+
+func coerceScalar(schema map[string]interface{}, value string) (interface{}, bool) {
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	default:
+		return value, true
+	}
+}
+
+// resolve 解析本文档内的`$ref`，仅支持`#/`开头的JSON Pointer
+func (mr *OpenAPIResponseMocker) resolve(v interface{}) map[string]interface{} {
+	obj := mr.object(v)
+	for i := 0; obj != nil && i < maxSchemaDepth; i++ {
+		ref, ok := obj["$ref"].(string)
+		if !ok {
+			return obj
+		}
+		obj = mr.object(mr.pointer(ref))
+	}
+	return obj
+}
+
+func (mr *OpenAPIResponseMocker) pointer(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var current interface{} = mr.doc
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = obj[token]
+	}
+	return current
+}
+
+func (mr *OpenAPIResponseMocker) object(v interface{}) map[string]interface{} {
+	obj, _ := v.(map[string]interface{})
+	return obj
+}
+
+func (mr *OpenAPIResponseMocker) array(v interface{}) []interface{} {
+	arr, _ := v.([]interface{})
+	return arr
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func openAPIParamString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// openAPIErrorResponse 生成json格式的错误响应
+func openAPIErrorResponse(statusCode int, message string, details ...string) *http.Response {
+	payload := map[string]interface{}{
+		"message": message,
+	}
+	if len(details) > 0 {
+		payload["errors"] = details
+	}
+	body, _ := json.Marshal(payload)
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          NewResponseBodyFromBytes(body),
+		ContentLength: int64(len(body)),
+	}
+}
+
+var (
+	_ ResponseMocker   = (*OpenAPIResponseMocker)(nil)
+	_ json.Unmarshaler = (*OpenAPIResponseMocker)(nil)
+)
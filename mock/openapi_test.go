@@ -0,0 +1,113 @@
+package mock_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestOpenAPIResponseMocker(t *testing.T) {
+	mocker, err := mock.UnmarshalResponseMocker([]byte(`{
+		"response_mocker": "OpenAPIResponseMocker",
+		"spec_file": "testdata/petstore.yaml",
+		"validate_request": true
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body string) (*http.Response, string) {
+		var rq *http.Request
+		if body == "" {
+			rq, _ = http.NewRequest(method, "http://localhost"+path, http.NoBody)
+		} else {
+			rq, _ = http.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+			rq.Header.Set("Content-Type", "application/json")
+		}
+		rp, err := mocker.Mock(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(rp.Body)
+		return rp, string(data)
+	}
+
+	rp, body := do("GET", "/pets?limit=10", "")
+	if rp.StatusCode != 200 || rp.Header.Get("Content-Type") != "application/json" || rp.Header.Get("X-Total") != "2" {
+		t.Fatalf("got %d %v", rp.StatusCode, rp.Header)
+	}
+	pet := gjson.Get(body, "0")
+	if pet.Get("name").String() != "string" || pet.Get("tag").String() != "dog" || pet.Get("id").Int() != 0 || pet.Get("birthday").String() != "1970-01-01" {
+		t.Fatalf("generated body %s", body)
+	}
+
+	rp, body = do("GET", "/v1/pets/7", "")
+	if rp.StatusCode != 200 || gjson.Get(body, "name").String() != "doggie" {
+		t.Fatalf("examples got %d %s", rp.StatusCode, body)
+	}
+	rp, body = do("GET", "/pets/mine", "")
+	if rp.StatusCode != 200 || body != "mine" || rp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("literal path should win, got %d %s", rp.StatusCode, body)
+	}
+	rp, body = do("POST", "/pets", `{"name": "kitty", "tag": "cat"}`)
+	if rp.StatusCode != 201 || gjson.Get(body, "id").Int() != 1 {
+		t.Fatalf("example got %d %s", rp.StatusCode, body)
+	}
+
+	rp, _ = do("GET", "/stores", "")
+	if rp.StatusCode != 404 {
+		t.Fatalf("unknown path should be 404, got %d", rp.StatusCode)
+	}
+	rp, _ = do("DELETE", "/pets", "")
+	if rp.StatusCode != 405 {
+		t.Fatalf("unknown method should be 405, got %d", rp.StatusCode)
+	}
+
+	rp, body = do("POST", "/pets", `{"tag": "bird"}`)
+	if rp.StatusCode != 400 || len(gjson.Get(body, "errors").Array()) != 1 {
+		t.Fatalf("invalid body should be 400, got %d %s", rp.StatusCode, body)
+	}
+	if !strings.Contains(body, "name") || !strings.Contains(body, "tag") {
+		t.Fatalf("errors should list all failures: %s", body)
+	}
+	rp, body = do("POST", "/pets", "")
+	if rp.StatusCode != 400 || !strings.Contains(body, "missing required request body") {
+		t.Fatalf("missing body should be 400, got %d %s", rp.StatusCode, body)
+	}
+	rp, body = do("GET", "/pets/abc", "")
+	if rp.StatusCode != 400 || !strings.Contains(body, "path parameter petId") {
+		t.Fatalf("invalid path parameter should be 400, got %d %s", rp.StatusCode, body)
+	}
+	rp, body = do("GET", "/pets?limit=1000", "")
+	if rp.StatusCode != 400 || !strings.Contains(body, "query parameter limit") {
+		t.Fatalf("invalid query parameter should be 400, got %d %s", rp.StatusCode, body)
+	}
+}
+
+func TestOpenAPIResponseMockerStatusCode(t *testing.T) {
+	mocker, err := mock.UnmarshalResponseMocker([]byte(`{
+		"response_mocker": "OpenAPIResponseMocker",
+		"spec_file": "testdata/petstore.yaml",
+		"status_code": 404
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq, _ := http.NewRequest("GET", "http://localhost/pets/1", nil)
+	rp, err := mocker.Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.StatusCode != 404 {
+		t.Fatalf("should use default response with status_code, got %d", rp.StatusCode)
+	}
+
+	_, err = mock.UnmarshalResponseMocker([]byte(`{"response_mocker": "OpenAPIResponseMocker", "spec": {"openapi": "3.0.0"}}`))
+	if err == nil {
+		t.Fatal("spec without paths should fail")
+	}
+}
@@ -0,0 +1,359 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/typemap"
+)
+
+var (
+	// ErrRecordingNotFound 回放时未找到特征值对应的录制响应
+	ErrRecordingNotFound = errors.New("recording not found")
+
+	// ErrRecordingTooLarge 响应Body超过MaxRecordingBodySize，不录制
+	ErrRecordingTooLarge = errors.New("response body too large to record")
+
+	// MaxRecordingBodySize 录制响应Body的上限，超过时不录制，响应仍完整返回
+	MaxRecordingBodySize int64 = 10 << 20
+)
+
+// Recorder 可选接口，透明ResponseMocker实现此接口时，Transport和Handler会将源服务的真实响应交给Record录制
+type Recorder interface {
+	Record(key string, rp *http.Response) error
+}
+
+// Recording 录制的响应，按请求特征值索引
+type Recording struct {
+	Key        string      `json:"key"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	RecordedAt time.Time   `json:"recorded_at"`
+}
+
+// NewRecording 根据真实响应生成Recording，会读取并回填rp.Body
+// NOTE: Body超过MaxRecordingBodySize时返回ErrRecordingTooLarge，已读取的部分与未读取的Body一起回填
+func NewRecording(key string, rp *http.Response) (*Recording, error) {
+	var body []byte
+	if rp.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(rp.Body, MaxRecordingBodySize+1))
+		if err == nil && int64(len(body)) > MaxRecordingBodySize {
+			rp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), rp.Body), rp.Body}
+			return nil, errors.WithMessagef(ErrRecordingTooLarge, "record %s", key)
+		}
+		rp.Body.Close()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read response body for %s failed", key)
+		}
+		rp.Body = NewResponseBodyFromBytes(body)
+	}
+	return &Recording{
+		Key:        key,
+		StatusCode: rp.StatusCode,
+		Header:     rp.Header.Clone(),
+		Body:       body,
+		RecordedAt: time.Now(),
+	}, nil
+}
+
+// Response 根据Recording生成响应
+func (rec *Recording) Response() *http.Response {
+	header := rec.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(rec.StatusCode),
+		StatusCode:    rec.StatusCode,
+		Header:        header,
+		Body:          NewResponseBodyFromBytes(rec.Body),
+		ContentLength: int64(len(rec.Body)),
+	}
+}
+
+// RecordingStore 录制响应的存储
+type RecordingStore interface {
+	Save(rec *Recording) error
+	Load(key string) (*Recording, error)
+}
+
+// GetRecordingStore 获取注册的RecordingStore
+// NOTE: 使用`typemap.MustRegister[RecordingStore](ctx, name, store)`注册，RecordingResponseMocker和ReplayResponseMocker通过store字段引用
+func GetRecordingStore(name string) (RecordingStore, error) {
+	store, err := typemap.Get[RecordingStore](context.Background(), name)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get recording store %s failed", name)
+	}
+	return store, nil
+}
+
+// recordingStore 按RecordingStore字段、store注册名、dir目录的优先级选择存储，均未指定时返回错误，避免写入进程的工作目录
+func recordingStore(store RecordingStore, name, dir string) (RecordingStore, error) {
+	if store != nil {
+		return store, nil
+	}
+	if name != "" {
+		return GetRecordingStore(name)
+	}
+	if dir == "" {
+		return nil, errors.New("recording store requires dir, store or RecordingStore")
+	}
+	return FileRecordingStore{Dir: dir}, nil
+}
+
+// FileRecordingStore 基于目录的RecordingStore，每个特征值对应一个json文件，文件名为特征值的sha1
+type FileRecordingStore struct {
+	Dir string
+}
+
+// Save 保存Recording，先写临时文件再rename，保证原子性
+func (s FileRecordingStore) Save(rec *Recording) error {
+	err := os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return errors.WithMessagef(err, "create recording dir %s failed", s.Dir)
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return errors.WithMessagef(err, "marshal recording %s failed", rec.Key)
+	}
+	f, err := ioutil.TempFile(s.Dir, ".recording-*")
+	if err != nil {
+		return errors.WithMessagef(err, "create temp file in %s failed", s.Dir)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.WithMessagef(err, "write recording %s failed", rec.Key)
+	}
+	err = os.Rename(f.Name(), s.path(rec.Key))
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.WithMessagef(err, "rename recording %s failed", rec.Key)
+	}
+	return nil
+}
+
+// Load 加载特征值对应的Recording，不存在时返回ErrRecordingNotFound
+func (s FileRecordingStore) Load(key string) (*Recording, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.WithMessagef(ErrRecordingNotFound, "load recording %s", key)
+		}
+		return nil, errors.WithMessagef(err, "read recording %s failed", key)
+	}
+	var rec Recording
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unmarshal recording %s failed", key)
+	}
+	return &rec, nil
+}
+
+func (s FileRecordingStore) path(key string) string {
+	return filepath.Join(s.Dir, eigenkey.SHA1(key)+".json")
+}
+
+// RecordingResponseMocker 录制Mocker，与TransparentResponseMocker一样从源服务获取真实响应，同时将响应按特征值录制到RecordingStore
+type RecordingResponseMocker struct {
+	*Options       `json:"options"`
+	Dir            string         `json:"dir"`             // NOTE: 未指定RecordingStore和Store时使用FileRecordingStore{Dir}
+	Store          string         `json:"store,omitempty"` // NOTE: 注册的RecordingStore名称，见GetRecordingStore
+	RecordingStore RecordingStore `json:"-"`               // NOTE: 代码中直接指定的存储，优先于Store和Dir
+}
+
+func (mr RecordingResponseMocker) ID() string {
+	return "RecordingResponseMocker"
+}
+
+func (mr RecordingResponseMocker) New() ResponseMocker {
+	return new(RecordingResponseMocker)
+}
+
+func (mr RecordingResponseMocker) IsTransparent() bool {
+	return true
+}
+
+func (mr RecordingResponseMocker) Mock(*http.Request) (*http.Response, error) {
+	panic("not implement -- RecordingResponseMocker should not use Mock method")
+}
+
+func (mr RecordingResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// Record 录制真实响应，会读取并回填rp.Body
+func (mr RecordingResponseMocker) Record(key string, rp *http.Response) error {
+	store, err := recordingStore(mr.RecordingStore, mr.Store, mr.Dir)
+	if err != nil {
+		return err
+	}
+	rec, err := NewRecording(key, rp)
+	if err != nil {
+		return err
+	}
+	return store.Save(rec)
+}
+
+// ReplayResponseMocker 回放Mocker，根据请求特征值从RecordingStore加载RecordingResponseMocker录制的响应
+// NOTE: 请求特征值通过EigenkeyFromContext获取，由Transport和Handler设置
+type ReplayResponseMocker struct {
+	*Options       `json:"options"`
+	Dir            string         `json:"dir"`             // NOTE: 未指定RecordingStore和Store时使用FileRecordingStore{Dir}
+	Store          string         `json:"store,omitempty"` // NOTE: 注册的RecordingStore名称，见GetRecordingStore
+	RecordingStore RecordingStore `json:"-"`               // NOTE: 代码中直接指定的存储，优先于Store和Dir
+}
+
+func (mr ReplayResponseMocker) ID() string {
+	return "ReplayResponseMocker"
+}
+
+func (mr ReplayResponseMocker) New() ResponseMocker {
+	return new(ReplayResponseMocker)
+}
+
+func (mr ReplayResponseMocker) IsTransparent() bool {
+	return false
+}
+
+func (mr ReplayResponseMocker) Mock(r *http.Request) (*http.Response, error) {
+	key, ok := EigenkeyFromContext(r.Context())
+	if !ok {
+		return nil, errors.New("replay response mocker requires eigenkey in request context")
+	}
+	store, err := recordingStore(mr.RecordingStore, mr.Store, mr.Dir)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := store.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Response(), nil
+}
+
+func (mr ReplayResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// recordingBody 包装真实响应的Body，读取时同时缓存，读到EOF时交给save录制，流式响应无需等待读完即可返回给调用方
+// NOTE: 未读到EOF(如调用方提前Close)时不录制
+type recordingBody struct {
+	io.ReadCloser
+	rp       *http.Response
+	body     bytes.Buffer
+	tooLarge bool
+	saved    bool
+	save     func(*http.Response, error)
+}
+
+// teeRecording 包装rp.Body，读到EOF时调用save，save收到的响应Body为完整的缓存，Body超过MaxRecordingBodySize时收到ErrRecordingTooLarge
+func teeRecording(rp *http.Response, save func(*http.Response, error)) {
+	captured := &http.Response{
+		Status:     rp.Status,
+		StatusCode: rp.StatusCode,
+		Header:     rp.Header.Clone(),
+	}
+	if rp.Body == nil || rp.Body == http.NoBody {
+		captured.Body = http.NoBody
+		save(captured, nil)
+		return
+	}
+	rp.Body = &recordingBody{ReadCloser: rp.Body, rp: captured, save: save}
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.tooLarge {
+		if int64(b.body.Len()+n) > MaxRecordingBodySize {
+			b.tooLarge = true
+			b.body = bytes.Buffer{}
+		} else {
+			b.body.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.saved {
+		b.saved = true
+		if b.tooLarge {
+			b.save(nil, ErrRecordingTooLarge)
+		} else {
+			b.rp.Body = NewResponseBodyFromBytes(b.body.Bytes())
+			b.save(b.rp, nil)
+		}
+	}
+	return n, err
+}
+
+// captureResponseWriter 记录写入的状态码、头和Body，用于Handler录制上游响应，Body超过MaxRecordingBodySize时不再缓存
+type captureResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	tooLarge   bool
+}
+
+func (w *captureResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *captureResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	if !w.tooLarge {
+		if int64(w.body.Len()+len(p)) > MaxRecordingBodySize {
+			w.tooLarge = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *captureResponseWriter) Response() *http.Response {
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     w.Header().Clone(),
+		Body:       NewResponseBodyFromBytes(w.body.Bytes()),
+	}
+}
+
+var (
+	_ ResponseMocker = (*RecordingResponseMocker)(nil)
+	_ ResponseMocker = (*ReplayResponseMocker)(nil)
+	_ Recorder       = (*RecordingResponseMocker)(nil)
+	_ RecordingStore = FileRecordingStore{}
+)
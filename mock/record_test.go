@@ -0,0 +1,202 @@
+package mock_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ccmonky/typemap"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Upstream", r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "real:"+r.URL.Path)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	dir := t.TempDir()
+
+	newMatcher := func(data string) *mock.EigenkeyMatcher {
+		return newEigenkeyMatcher(t, nil, map[string]string{"/a": data, "/b": data})
+	}
+
+	// record /a via Transport, /b via Handler
+	recorder := newMatcher(fmt.Sprintf(`{"response_mocker": "RecordingResponseMocker", "dir": %q}`, dir))
+	client := &http.Client{Transport: mock.NewTransport(recorder, nil)}
+	rp, err := client.Get(upstream.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "real:/a" {
+		t.Fatalf("body should be restored after recording, got %s", body)
+	}
+	ts := httptest.NewServer(mock.NewHandler(recorder, target))
+	defer ts.Close()
+	rp, err = http.Get(ts.URL + "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(rp.Body)
+	if string(body) != "real:/b" {
+		t.Fatalf("should be proxied, got %s", body)
+	}
+	if calls != 2 {
+		t.Fatalf("upstream should be called twice, got %d", calls)
+	}
+
+	// replay both without upstream
+	replayer := newMatcher(fmt.Sprintf(`{"response_mocker": "ReplayResponseMocker", "dir": %q}`, dir))
+	client = &http.Client{Transport: mock.NewTransport(replayer, nil)}
+	for _, path := range []string{"/a", "/b"} {
+		rp, err = client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(rp.Body)
+		if rp.StatusCode != http.StatusAccepted || string(body) != "real:"+path || rp.Header.Get("X-Upstream") != path {
+			t.Fatalf("should replay %s, got %d %s", path, rp.StatusCode, body)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("upstream should not be called on replay, got %d", calls)
+	}
+
+	_, err = mock.FileRecordingStore{Dir: dir}.Load("/c")
+	if err == nil {
+		t.Fatal("should not found")
+	}
+}
+
+// memRecordingStore 基于内存的RecordingStore
+type memRecordingStore struct {
+	lock       sync.Mutex
+	recordings map[string]*mock.Recording
+}
+
+func (s *memRecordingStore) Save(rec *mock.Recording) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.recordings == nil {
+		s.recordings = make(map[string]*mock.Recording)
+	}
+	s.recordings[rec.Key] = rec
+	return nil
+}
+
+func (s *memRecordingStore) Load(key string) (*mock.Recording, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rec, ok := s.recordings[key]
+	if !ok {
+		return nil, mock.ErrRecordingNotFound
+	}
+	return rec, nil
+}
+
+func TestRecordingStore(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "real:"+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	// RecordingStore字段
+	store := &memRecordingStore{}
+	recorder := newEigenkeyMatcher(t, nil, nil)
+	recorder.Set("/a", &mock.RecordingResponseMocker{RecordingStore: store})
+	rp, err := (&http.Client{Transport: mock.NewTransport(recorder, nil)}).Get(upstream.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: 边读边录制，Body读到EOF时保存
+	ioutil.ReadAll(rp.Body)
+	rp.Body.Close()
+	if rec, err := store.Load("/a"); err != nil || string(rec.Body) != "real:/a" {
+		t.Fatal("should record into RecordingStore", err)
+	}
+
+	// 注册的store
+	if err = typemap.Register[mock.RecordingStore](context.Background(), "test-mem", store); err != nil {
+		t.Fatal(err)
+	}
+	replayer := newEigenkeyMatcher(t, nil, map[string]string{
+		"/a": `{"response_mocker": "ReplayResponseMocker", "store": "test-mem"}`,
+		"/b": `{"response_mocker": "ReplayResponseMocker", "store": "not-exists"}`,
+	})
+	client := &http.Client{Transport: mock.NewTransport(replayer, nil)}
+	rp, err = client.Get(upstream.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "real:/a" {
+		t.Fatalf("should replay from registered store, got %s", body)
+	}
+	if _, err = client.Get(upstream.URL + "/b"); err == nil {
+		t.Fatal("should fail for unknown store")
+	}
+}
+
+func TestRecordingFailureAndStreaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first;")
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/stream" {
+			<-release
+		}
+		io.WriteString(w, "last")
+	}))
+	defer upstream.Close()
+
+	// NOTE: 未指定dir和store时录制失败，仅记录日志，真实响应照常返回
+	var logs strings.Builder
+	matcher := newEigenkeyMatcher(t, nil, map[string]string{
+		"/nodir":  `{"response_mocker": "RecordingResponseMocker"}`,
+		"/stream": `{"response_mocker": "RecordingResponseMocker", "store": "test-stream"}`,
+	})
+	transport := mock.NewTransport(matcher, nil)
+	transport.ErrorLog = log.New(&logs, "", 0)
+	client := &http.Client{Transport: transport}
+	rp, err := client.Get(upstream.URL + "/nodir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "first;last" || !strings.Contains(logs.String(), "record response") {
+		t.Fatalf("recording failure should be logged, got %s %q", body, logs.String())
+	}
+
+	// NOTE: 流式响应录制时不等待上游结束
+	store := &memRecordingStore{}
+	if err = typemap.Register[mock.RecordingStore](context.Background(), "test-stream", store); err != nil {
+		t.Fatal(err)
+	}
+	rp, err = client.Get(upstream.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("first;"))
+	if _, err = io.ReadFull(rp.Body, first); err != nil || string(first) != "first;" {
+		t.Fatalf("should read first chunk before upstream ends, got %s %v", first, err)
+	}
+	close(release)
+	ioutil.ReadAll(rp.Body)
+	if rec, err := store.Load("/stream"); err != nil || string(rec.Body) != "first;last" {
+		t.Fatal("should record whole stream", err)
+	}
+}
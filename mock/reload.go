@@ -0,0 +1,147 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Reloader 可热加载规则的Matcher，Reload需先完整解析并校验data，成功后再整体替换规则，失败时保留原规则
+type Reloader interface {
+	Reload(data []byte) error
+}
+
+// ErrEmptyRules 规则内容为空或null
+var ErrEmptyRules = errors.New("rules are empty, use [] to clear rules explicitly")
+
+// Reload 从json或yaml格式的规则列表热加载规则，格式同LoadRules
+func (m *RuleMatcher) Reload(data []byte) error {
+	rules, err := LoadRules(data)
+	if err != nil {
+		return err
+	}
+	return m.SetRules(rules)
+}
+
+// Reload 从json或yaml格式的规则表热加载规则，key为请求特征值，value使用UnmarshalResponseMocker解析
+func (m *EigenkeyMatcher) Reload(data []byte) error {
+	data, err := rulesToJSON(data)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return errors.WithMessage(err, "unmarshal rules failed")
+	}
+	rules := make(map[string]ResponseMocker, len(raw))
+	for key, rule := range raw {
+		mocker, err := UnmarshalResponseMocker(rule)
+		if err != nil {
+			return errors.WithMessagef(err, "unmarshal rule for eigenkey %s failed", key)
+		}
+		rules[key] = mocker
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Rules = rules
+	return nil
+}
+
+// RuleWatcher 监听规则文件，内容变化时通过Reloader热加载规则
+// NOTE:
+// 1. 通过定时读取文件内容判断变化，不依赖文件系统通知;
+// 2. 加载失败时保留原规则，并通过OnError和LastError报告错误;
+// 3. 规则整体替换，进行中的请求继续使用替换前的ResponseMocker
+type RuleWatcher struct {
+	Path     string
+	Reloader Reloader
+	Interval time.Duration     // NOTE: 默认1s
+	OnError  func(err error)   // NOTE: 加载失败时回调
+	OnReload func(data []byte) // NOTE: 加载成功时回调
+
+	lock    sync.RWMutex
+	content []byte
+	lastErr error
+}
+
+// NewRuleWatcher 新建RuleWatcher
+func NewRuleWatcher(path string, reloader Reloader) *RuleWatcher {
+	return &RuleWatcher{
+		Path:     path,
+		Reloader: reloader,
+	}
+}
+
+// Load 读取规则文件，内容变化时热加载
+func (w *RuleWatcher) Load() error {
+	data, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return w.report(errors.WithMessagef(err, "read rule file %s failed", w.Path))
+	}
+	w.lock.RLock()
+	unchanged := w.content != nil && bytes.Equal(data, w.content)
+	w.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	err = w.Reloader.Reload(data)
+	w.lock.Lock()
+	w.content = data // NOTE: 失败时也记录内容，避免重复报告同一错误
+	w.lock.Unlock()
+	if err != nil {
+		return w.report(errors.WithMessagef(err, "reload rule file %s failed", w.Path))
+	}
+	w.lock.Lock()
+	w.lastErr = nil
+	w.lock.Unlock()
+	if w.OnReload != nil {
+		w.OnReload(data)
+	}
+	return nil
+}
+
+// Watch 定时检查规则文件直到ctx结束，返回ctx.Err()
+func (w *RuleWatcher) Watch(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_ = w.Load()
+		}
+	}
+}
+
+// LastError 返回最近一次加载的错误，加载成功后重置为nil
+func (w *RuleWatcher) LastError() error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.lastErr
+}
+
+func (w *RuleWatcher) report(err error) error {
+	w.lock.Lock()
+	w.lastErr = err
+	w.lock.Unlock()
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+	return err
+}
+
+var (
+	_ Reloader = (*RuleMatcher)(nil)
+	_ Reloader = (*EigenkeyMatcher)(nil)
+)
@@ -0,0 +1,108 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestRuleWatcher(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statusCode := func(matcher mock.Matcher) int {
+		rq, _ := http.NewRequest("GET", "http://localhost/a", nil)
+		_, mocker, err := matcher.Match(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mocker == nil {
+			return 0
+		}
+		rp, err := mocker.Mock(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp.StatusCode
+	}
+
+	matcher := &mock.RuleMatcher{}
+	if err := matcher.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	write(`[{"name": "a", "path": "/a", "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 201}}]`)
+	errs := make(chan error, 10)
+	watcher := mock.NewRuleWatcher(file, matcher)
+	watcher.Interval = 10 * time.Millisecond
+	watcher.OnError = func(err error) { errs <- err }
+	if err := watcher.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode(matcher) != 201 {
+		t.Fatal("should load rules")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx)
+
+	write(`[{"name": "a", "path": "/a", "mocker": {"response_mocker": "NotExists"}}]`)
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("should report error")
+	}
+	if watcher.LastError() == nil || statusCode(matcher) != 201 {
+		t.Fatal("should keep previous rules on error")
+	}
+
+	write(`[{"name": "a", "path": "/a", "mocker": {"response_mocker": "ResponseMockerBuilder", "status_code": 202}}]`)
+	deadline := time.Now().Add(time.Second)
+	for statusCode(matcher) != 202 {
+		if time.Now().After(deadline) {
+			t.Fatal("should reload rules")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if watcher.LastError() != nil {
+		t.Fatal("last error should be reset")
+	}
+
+	// NOTE: 编辑器截断文件时读到空内容，应保留原规则并报告错误
+	for _, content := range []string{"", "  \n", "null", "~"} {
+		write(content)
+		if err := watcher.Load(); !errors.Is(err, mock.ErrEmptyRules) {
+			t.Fatalf("%q should be rejected, got %v", content, err)
+		}
+		if statusCode(matcher) != 202 {
+			t.Fatalf("%q should keep previous rules", content)
+		}
+	}
+	if err := matcher.Reload([]byte("[]")); err != nil || len(matcher.GetRules()) != 0 {
+		t.Fatal("explicit [] should clear rules", err)
+	}
+
+	em := mock.NewEigenkeyMatcher(nil)
+	if err := em.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.Reload([]byte("")); !errors.Is(err, mock.ErrEmptyRules) {
+		t.Fatal("eigenkey matcher should reject empty rules", err)
+	}
+	err := em.Reload([]byte("/a:\n  response_mocker: ResponseMockerBuilder\n  status_code: 203\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode(em) != 203 {
+		t.Fatal("eigenkey matcher should reload yaml rules")
+	}
+}
@@ -0,0 +1,436 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// methodRules 按方法名查找ResponseMocker的规则表，由GRPCMatcher和JSONRPCMatcher共用
+type methodRules struct {
+	Rules map[string]ResponseMocker `json:"-"`
+
+	lock sync.RWMutex
+}
+
+// UnmarshalJSON 解析json配置，其中rules的每个值均使用UnmarshalResponseMocker解析
+func (m *methodRules) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Rules json.RawMessage `json:"rules"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return errors.WithMessage(err, "unmarshal method matcher failed")
+	}
+	if len(raw.Rules) == 0 {
+		return nil
+	}
+	return m.Reload(raw.Rules)
+}
+
+// MarshalJSON 序列化json配置
+func (m *methodRules) MarshalJSON() ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	rules := make(map[string]json.RawMessage, len(m.Rules))
+	for key, mocker := range m.Rules {
+		data, err := MarshalResponseMocker(mocker)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "marshal rule for method %s failed", key)
+		}
+		rules[key] = data
+	}
+	return json.Marshal(map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+// Reload 从json或yaml格式的规则表热加载规则，key为方法名，value使用UnmarshalResponseMocker解析
+func (m *methodRules) Reload(data []byte) error {
+	data, err := rulesToJSON(data)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return errors.WithMessage(err, "unmarshal rules failed")
+	}
+	rules := make(map[string]ResponseMocker, len(raw))
+	for key, rule := range raw {
+		mocker, err := UnmarshalResponseMocker(rule)
+		if err != nil {
+			return errors.WithMessagef(err, "unmarshal rule for method %s failed", key)
+		}
+		rules[key] = mocker
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Rules = rules
+	return nil
+}
+
+// Set 设置方法名对应的ResponseMocker，并发安全
+func (m *methodRules) Set(key string, mocker ResponseMocker) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.Rules == nil {
+		m.Rules = make(map[string]ResponseMocker)
+	}
+	m.Rules[key] = mocker
+}
+
+// Delete 删除方法名对应的ResponseMocker，并发安全
+func (m *methodRules) Delete(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.Rules, key)
+}
+
+// lookup 依次查找keys，返回第一个存在的ResponseMocker
+func (m *methodRules) lookup(keys ...string) ResponseMocker {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, key := range keys {
+		if mocker, ok := m.Rules[key]; ok {
+			return mocker
+		}
+	}
+	return nil
+}
+
+// IsGRPCRequest 判断是否为gRPC请求，即POST且Content-Type为application/grpc或application/grpc+xxx
+func IsGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return r.Method == http.MethodPost && (contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") || strings.HasPrefix(contentType, "application/grpc;"))
+}
+
+// ParseGRPCPath 解析gRPC请求path，如`/helloworld.Greeter/SayHello`返回`helloworld.Greeter`和`SayHello`
+func ParseGRPCPath(path string) (service, method string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// GRPCMatcher 按gRPC请求path中的service和method匹配ResponseMocker，非gRPC请求不匹配
+// NOTE: 规则key依次查找`service/method`、`service/*`和`*`，请求特征值为`service/method`
+type GRPCMatcher struct {
+	methodRules
+}
+
+// NewGRPCMatcher 新建GRPCMatcher
+func NewGRPCMatcher() *GRPCMatcher {
+	return &GRPCMatcher{methodRules{Rules: make(map[string]ResponseMocker)}}
+}
+
+// Eigenkey 计算请求特征值`service/method`
+func (m *GRPCMatcher) Eigenkey(r *http.Request) (string, error) {
+	if !IsGRPCRequest(r) {
+		return "", errors.Errorf("%s %s is not a grpc request", r.Method, r.URL.Path)
+	}
+	service, method, ok := ParseGRPCPath(r.URL.Path)
+	if !ok {
+		return "", errors.Errorf("invalid grpc path %s", r.URL.Path)
+	}
+	return service + "/" + method, nil
+}
+
+// Match 查找gRPC方法对应的ResponseMocker，非gRPC请求时特征值和ResponseMocker均为空
+func (m *GRPCMatcher) Match(r *http.Request) (string, ResponseMocker, error) {
+	key, err := m.Eigenkey(r)
+	if err != nil {
+		return "", nil, nil
+	}
+	service := key[:strings.Index(key, "/")]
+	return key, m.lookup(key, service+"/*", "*"), nil
+}
+
+// JSONRPCRequest JSON-RPC 2.0请求
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // NOTE: 为空表示通知，不需要响应
+}
+
+// IsNotification 是否为通知
+func (req *JSONRPCRequest) IsNotification() bool {
+	return len(req.ID) == 0
+}
+
+// ParseJSONRPCRequest 解析JSON-RPC请求Body，batch表示是否为批量请求
+func ParseJSONRPCRequest(body []byte) (requests []*JSONRPCRequest, batch bool, err error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		batch = true
+		err = json.Unmarshal(body, &requests)
+	} else {
+		var req JSONRPCRequest
+		err = json.Unmarshal(body, &req)
+		requests = []*JSONRPCRequest{&req}
+	}
+	if err != nil {
+		return nil, false, errors.WithMessage(err, "unmarshal json-rpc request failed")
+	}
+	if len(requests) == 0 {
+		return nil, false, errors.New("empty json-rpc batch")
+	}
+	for _, req := range requests {
+		if req.Method == "" {
+			return nil, false, errors.New("json-rpc request without method")
+		}
+	}
+	return requests, batch, nil
+}
+
+// JSONRPCMatcher 按JSON-RPC请求Body中的method匹配ResponseMocker，非JSON-RPC请求不匹配
+// NOTE: 规则key依次查找method和`*`，批量请求使用第一个请求的method，请求特征值为method
+type JSONRPCMatcher struct {
+	methodRules
+}
+
+// NewJSONRPCMatcher 新建JSONRPCMatcher
+func NewJSONRPCMatcher() *JSONRPCMatcher {
+	return &JSONRPCMatcher{methodRules{Rules: make(map[string]ResponseMocker)}}
+}
+
+// Eigenkey 计算请求特征值，即method
+func (m *JSONRPCMatcher) Eigenkey(r *http.Request) (string, error) {
+	if r.Method != http.MethodPost {
+		return "", errors.Errorf("%s %s is not a json-rpc request", r.Method, r.URL.Path)
+	}
+	body, err := utils.ReadAndRestoreBody(r)
+	if err != nil {
+		return "", err
+	}
+	requests, _, err := ParseJSONRPCRequest(body)
+	if err != nil {
+		return "", err
+	}
+	return requests[0].Method, nil
+}
+
+// Match 查找JSON-RPC方法对应的ResponseMocker，非JSON-RPC请求时特征值和ResponseMocker均为空
+func (m *JSONRPCMatcher) Match(r *http.Request) (string, ResponseMocker, error) {
+	key, err := m.Eigenkey(r)
+	if err != nil {
+		return "", nil, nil
+	}
+	return key, m.lookup(key, "*"), nil
+}
+
+// GRPCResponseMocker 生成gRPC响应：Body为长度前缀的消息帧，grpc-status和grpc-message在trailer中
+// NOTE: 真实gRPC客户端需要HTTP/2，Handler需运行在支持HTTP/2(或h2c)的Server上
+type GRPCResponseMocker struct {
+	*Options     `json:"options"`
+	Messages     [][]byte          `json:"messages,omitempty"`      // NOTE: 已序列化的protobuf消息，json中为base64
+	JSONMessages []json.RawMessage `json:"json_messages,omitempty"` // NOTE: application/grpc+json使用的json消息，追加在messages之后
+	Status       int               `json:"status,omitempty"`        // NOTE: grpc-status，默认0即OK
+	Message      string            `json:"message,omitempty"`       // NOTE: grpc-message
+	Header       http.Header       `json:"header,omitempty"`
+	Trailer      http.Header       `json:"trailer,omitempty"`
+}
+
+func (mr *GRPCResponseMocker) ID() string {
+	return "GRPCResponseMocker"
+}
+
+func (mr *GRPCResponseMocker) New() ResponseMocker {
+	return new(GRPCResponseMocker)
+}
+
+func (mr *GRPCResponseMocker) IsTransparent() bool {
+	return false
+}
+
+func (mr *GRPCResponseMocker) Mock(r *http.Request) (*http.Response, error) {
+	var body bytes.Buffer
+	for _, msg := range mr.Messages {
+		writeGRPCFrame(&body, msg)
+	}
+	for i, msg := range mr.JSONMessages {
+		var compacted bytes.Buffer
+		err := json.Compact(&compacted, msg)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "compact json message %d failed", i)
+		}
+		writeGRPCFrame(&body, compacted.Bytes())
+	}
+	header := mr.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Content-Type") == "" {
+		contentType := r.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, "application/grpc") {
+			contentType = "application/grpc"
+		}
+		header.Set("Content-Type", contentType)
+	}
+	trailer := mr.Trailer.Clone()
+	if trailer == nil {
+		trailer = http.Header{}
+	}
+	trailer.Set("Grpc-Status", strconv.Itoa(mr.Status))
+	if mr.Message != "" {
+		trailer.Set("Grpc-Message", EncodeGRPCMessage(mr.Message))
+	}
+	return &http.Response{
+		Status:        "200",
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Trailer:       trailer,
+		Body:          NewResponseBodyFromBytes(body.Bytes()),
+		ContentLength: -1,
+	}, nil
+}
+
+func (mr *GRPCResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// writeGRPCFrame 写入一个gRPC消息帧：1字节压缩标志(0) + 4字节大端长度 + 消息
+func writeGRPCFrame(buf *bytes.Buffer, msg []byte) {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	buf.Write(prefix[:])
+	buf.Write(msg)
+}
+
+// ReadGRPCFrames 解析长度前缀的gRPC消息帧，不支持压缩的消息
+func ReadGRPCFrames(data []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, errors.New("incomplete grpc frame prefix")
+		}
+		if data[0] != 0 {
+			return nil, errors.New("compressed grpc frame is not supported")
+		}
+		n := binary.BigEndian.Uint32(data[1:5])
+		if uint64(len(data)-5) < uint64(n) {
+			return nil, errors.New("incomplete grpc frame")
+		}
+		msgs = append(msgs, data[5:5+n])
+		data = data[5+n:]
+	}
+	return msgs, nil
+}
+
+// EncodeGRPCMessage 按gRPC协议对grpc-message进行百分号编码
+func EncodeGRPCMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+// JSONRPCError JSON-RPC错误对象
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// JSONRPCResponseMocker 生成JSON-RPC 2.0响应，id与请求一致，批量请求返回数组，全部为通知时返回204
+type JSONRPCResponseMocker struct {
+	*Options `json:"options"`
+	Result   json.RawMessage `json:"result,omitempty"` // NOTE: 为空且无error时result为null
+	Error    *JSONRPCError   `json:"error,omitempty"`  // NOTE: 指定时返回错误而非result
+}
+
+func (mr *JSONRPCResponseMocker) ID() string {
+	return "JSONRPCResponseMocker"
+}
+
+func (mr *JSONRPCResponseMocker) New() ResponseMocker {
+	return new(JSONRPCResponseMocker)
+}
+
+func (mr *JSONRPCResponseMocker) IsTransparent() bool {
+	return false
+}
+
+func (mr *JSONRPCResponseMocker) Mock(r *http.Request) (*http.Response, error) {
+	body, err := utils.ReadAndRestoreBody(r)
+	if err != nil {
+		return nil, err
+	}
+	requests, batch, err := ParseJSONRPCRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	var responses []map[string]interface{}
+	for _, req := range requests {
+		if req.IsNotification() {
+			continue
+		}
+		resp := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+		}
+		if mr.Error != nil {
+			resp["error"] = mr.Error
+		} else if len(mr.Result) > 0 {
+			resp["result"] = mr.Result
+		} else {
+			resp["result"] = nil
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) == 0 {
+		return &http.Response{
+			Status:     strconv.Itoa(http.StatusNoContent),
+			StatusCode: http.StatusNoContent,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+		}, nil
+	}
+	var data []byte
+	if batch {
+		data, err = json.Marshal(responses)
+	} else {
+		data, err = json.Marshal(responses[0])
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "marshal json-rpc response failed")
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(http.StatusOK),
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          NewResponseBodyFromBytes(data),
+		ContentLength: int64(len(data)),
+	}, nil
+}
+
+func (mr *JSONRPCResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+var (
+	_ Matcher        = (*GRPCMatcher)(nil)
+	_ Matcher        = (*JSONRPCMatcher)(nil)
+	_ Reloader       = (*GRPCMatcher)(nil)
+	_ Reloader       = (*JSONRPCMatcher)(nil)
+	_ ResponseMocker = (*GRPCResponseMocker)(nil)
+	_ ResponseMocker = (*JSONRPCResponseMocker)(nil)
+)
@@ -0,0 +1,126 @@
+package mock_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestGRPCMatcher(t *testing.T) {
+	var matcher mock.GRPCMatcher
+	err := json.Unmarshal([]byte(`{"rules": {
+		"helloworld.Greeter/SayHello": {"response_mocker": "GRPCResponseMocker", "messages": ["aGVsbG8="], "header": {"X-Mock": ["1"]}},
+		"helloworld.Greeter/*": {"response_mocker": "GRPCResponseMocker", "status": 12, "message": "not implemented: 100%"}
+	}}`), &matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mock.NewHandler(&matcher, nil))
+	defer ts.Close()
+
+	call := func(path, contentType string) *http.Response {
+		rq, _ := http.NewRequest("POST", ts.URL+path, bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+		rq.Header.Set("Content-Type", contentType)
+		rp, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
+
+	rp := call("/helloworld.Greeter/SayHello", "application/grpc+proto")
+	body, _ := ioutil.ReadAll(rp.Body)
+	msgs, err := mock.ReadGRPCFrames(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0]) != "hello" || rp.Header.Get("Content-Type") != "application/grpc+proto" || rp.Header.Get("X-Mock") != "1" {
+		t.Fatalf("got %q %v", msgs, rp.Header)
+	}
+	if rp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("got trailer %v", rp.Trailer)
+	}
+
+	rp = call("/helloworld.Greeter/SayBye", "application/grpc")
+	body, _ = ioutil.ReadAll(rp.Body)
+	if len(body) != 0 || rp.Trailer.Get("Grpc-Status") != "12" || rp.Trailer.Get("Grpc-Message") != "not implemented: 100%25" {
+		t.Fatalf("got %q trailer %v", body, rp.Trailer)
+	}
+
+	rp = call("/helloworld.Greeter/SayHello", "application/json")
+	if rp.StatusCode != http.StatusNotFound {
+		t.Fatalf("non grpc request should not match, got %d", rp.StatusCode)
+	}
+	rq, _ := http.NewRequest("POST", "http://localhost/other.Service/Method", nil)
+	rq.Header.Set("Content-Type", "application/grpc")
+	key, mocker, err := matcher.Match(rq)
+	if err != nil || key != "other.Service/Method" || mocker != nil {
+		t.Fatalf("got %s %v %v", key, mocker, err)
+	}
+
+	data, err := json.Marshal(&matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"response_mocker":"GRPCResponseMocker"`) {
+		t.Fatalf("got %s", data)
+	}
+}
+
+func TestJSONRPCMatcher(t *testing.T) {
+	matcher := mock.NewJSONRPCMatcher()
+	err := matcher.Reload([]byte(`
+eth_blockNumber:
+  response_mocker: JSONRPCResponseMocker
+  result: "0x10"
+"*":
+  response_mocker: JSONRPCResponseMocker
+  error:
+    code: -32601
+    message: Method not found
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	call := func(body string) (*http.Response, string) {
+		rp, err := client.Post("http://localhost/rpc", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(rp.Body)
+		return rp, string(data)
+	}
+
+	var cases = []struct {
+		request, response string
+	}{
+		{`{"jsonrpc": "2.0", "method": "eth_blockNumber", "id": 7}`, `{"id":7,"jsonrpc":"2.0","result":"0x10"}`},
+		{`{"jsonrpc": "2.0", "method": "eth_blockNumber", "id": "abc"}`, `{"id":"abc","jsonrpc":"2.0","result":"0x10"}`},
+		{`{"jsonrpc": "2.0", "method": "unknown", "id": 1}`, `{"error":{"code":-32601,"message":"Method not found"},"id":1,"jsonrpc":"2.0"}`},
+		{`[{"jsonrpc": "2.0", "method": "eth_blockNumber", "id": 1}, {"jsonrpc": "2.0", "method": "eth_blockNumber"}, {"jsonrpc": "2.0", "method": "eth_blockNumber", "id": 2}]`,
+			`[{"id":1,"jsonrpc":"2.0","result":"0x10"},{"id":2,"jsonrpc":"2.0","result":"0x10"}]`},
+	}
+	for _, c := range cases {
+		rp, body := call(c.request)
+		if rp.StatusCode != 200 || body != c.response {
+			t.Fatalf("%s got %d %s", c.request, rp.StatusCode, body)
+		}
+	}
+	rp, _ := call(`{"jsonrpc": "2.0", "method": "eth_blockNumber"}`)
+	if rp.StatusCode != http.StatusNoContent {
+		t.Fatalf("notification should get 204, got %d", rp.StatusCode)
+	}
+
+	rq, _ := http.NewRequest("POST", "http://localhost/rpc", strings.NewReader(`{"not": "rpc"}`))
+	key, mocker, err := matcher.Match(rq)
+	if err != nil || key != "" || mocker != nil {
+		t.Fatalf("non json-rpc request should not match, got %s %v %v", key, mocker, err)
+	}
+}
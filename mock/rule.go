@@ -0,0 +1,481 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/utils"
+)
+
+// BodyPredicate 操作符
+const (
+	OpExists    = "exists"
+	OpNotExists = "not_exists"
+	OpEq        = "eq"
+	OpNe        = "ne"
+	OpRegex     = "regex"
+	OpGt        = "gt"
+	OpLt        = "lt"
+)
+
+// Rule 基于请求条件的Mock规则，所有指定的条件均满足时匹配
+type Rule struct {
+	Name          string            `json:"name"`
+	Priority      int               `json:"priority"`                 // NOTE: 越大越优先，相同时按定义顺序
+	Disabled      bool              `json:"disabled,omitempty"`       // NOTE: 禁用的规则不参与匹配
+	Methods       []string          `json:"methods,omitempty"`        // NOTE: 任一相等即可，不区分大小写
+	Path          string            `json:"path,omitempty"`           // NOTE: glob，语法同path.Match
+	PathRegexp    string            `json:"path_regexp,omitempty"`    // NOTE: 正则
+	Headers       map[string]string `json:"headers,omitempty"`        // NOTE: 相等
+	HeaderRegexps map[string]string `json:"header_regexps,omitempty"` // NOTE: 正则
+	Query         map[string]string `json:"query,omitempty"`          // NOTE: 相等
+	QueryRegexps  map[string]string `json:"query_regexps,omitempty"`  // NOTE: 正则
+	Body          []BodyPredicate   `json:"body,omitempty"`           // NOTE: 基于gjson path的json body断言
+	Scenario      string            `json:"scenario,omitempty"`       // NOTE: 所属场景，场景状态按RuleMatcher.Session区分会话
+	RequiredState string            `json:"required_state,omitempty"` // NOTE: 场景处于该状态时才匹配，为空时任意状态均匹配
+	NewState      string            `json:"new_state,omitempty"`      // NOTE: 匹配后场景转移到的状态，为空时不转移
+	Mocker        ResponseMocker    `json:"-"`
+
+	provisioned   bool
+	id            uint64 // NOTE: Provision时生成，未命名规则的命中统计按id记录
+	pathRegexp    *regexp.Regexp
+	headerRegexps map[string]*regexp.Regexp
+	queryRegexps  map[string]*regexp.Regexp
+}
+
+// BodyPredicate json body断言
+type BodyPredicate struct {
+	Path  string      `json:"path"`
+	Op    string      `json:"op"` // NOTE: 默认eq
+	Value interface{} `json:"value,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// UnmarshalJSON 使用UnmarshalResponseMocker解析mocker字段
+func (rule *Rule) UnmarshalJSON(data []byte) error {
+	type plain Rule
+	var raw struct {
+		*plain
+		Mocker json.RawMessage `json:"mocker"`
+	}
+	raw.plain = (*plain)(rule)
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	rule.Mocker, err = UnmarshalResponseMocker(raw.Mocker)
+	if err != nil {
+		return errors.WithMessagef(err, "unmarshal mocker of rule %s failed", rule.Name)
+	}
+	return nil
+}
+
+// MarshalJSON 使用MarshalResponseMocker序列化mocker字段
+func (rule *Rule) MarshalJSON() ([]byte, error) {
+	type plain Rule
+	mocker, err := MarshalResponseMocker(rule.Mocker)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "marshal mocker of rule %s failed", rule.Name)
+	}
+	return json.Marshal(struct {
+		*plain
+		Mocker json.RawMessage `json:"mocker"`
+	}{(*plain)(rule), mocker})
+}
+
+// Provision 编译正则并校验规则，已初始化的规则直接返回，因此规则生效后不应再修改，而应新建规则替换
+func (rule *Rule) Provision() error {
+	if rule.provisioned {
+		return nil
+	}
+	var err error
+	rule.id = atomic.AddUint64(&ruleSeq, 1)
+	if rule.Mocker == nil {
+		rule.Mocker = new(TransparentResponseMocker)
+	}
+	if rule.Scenario == "" && (rule.RequiredState != "" || rule.NewState != "") {
+		return errors.Errorf("rule %s has scenario states but no scenario", rule.Name)
+	}
+	if rule.Path != "" {
+		if _, err = path.Match(rule.Path, ""); err != nil {
+			return errors.WithMessagef(err, "rule %s has invalid path glob %s", rule.Name, rule.Path)
+		}
+	}
+	if rule.PathRegexp != "" {
+		rule.pathRegexp, err = regexp.Compile(rule.PathRegexp)
+		if err != nil {
+			return errors.WithMessagef(err, "rule %s has invalid path regexp", rule.Name)
+		}
+	}
+	rule.headerRegexps, err = compileRegexps(rule.HeaderRegexps)
+	if err != nil {
+		return errors.WithMessagef(err, "rule %s has invalid header regexp", rule.Name)
+	}
+	rule.queryRegexps, err = compileRegexps(rule.QueryRegexps)
+	if err != nil {
+		return errors.WithMessagef(err, "rule %s has invalid query regexp", rule.Name)
+	}
+	for i := range rule.Body {
+		p := &rule.Body[i]
+		switch p.Op {
+		case "":
+			p.Op = OpEq
+		case OpRegex:
+			p.regexp, err = regexp.Compile(fmt.Sprint(p.Value))
+			if err != nil {
+				return errors.WithMessagef(err, "rule %s has invalid body regexp for %s", rule.Name, p.Path)
+			}
+		case OpExists, OpNotExists, OpEq, OpNe, OpGt, OpLt:
+		default:
+			return errors.Errorf("rule %s has invalid body op %s for %s", rule.Name, p.Op, p.Path)
+		}
+	}
+	rule.provisioned = true
+	return nil
+}
+
+// Explain 判断请求是否满足规则，不满足时返回第一个不满足的条件描述，满足时返回空字符串
+// NOTE: body为请求Body，由调用方读取并回填
+func (rule *Rule) Explain(r *http.Request, body []byte) string {
+	if rule.Disabled {
+		return "disabled"
+	}
+	if len(rule.Methods) > 0 {
+		var ok bool
+		for _, method := range rule.Methods {
+			if strings.EqualFold(method, r.Method) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Sprintf("method %s not in %v", r.Method, rule.Methods)
+		}
+	}
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, r.URL.Path); !ok {
+			return fmt.Sprintf("path %s not match glob %s", r.URL.Path, rule.Path)
+		}
+	}
+	if rule.pathRegexp != nil && !rule.pathRegexp.MatchString(r.URL.Path) {
+		return fmt.Sprintf("path %s not match regexp %s", r.URL.Path, rule.PathRegexp)
+	}
+	for k, v := range rule.Headers {
+		if r.Header.Get(k) != v {
+			return fmt.Sprintf("header %s != %s", k, v)
+		}
+	}
+	for k, re := range rule.headerRegexps {
+		if !re.MatchString(r.Header.Get(k)) {
+			return fmt.Sprintf("header %s not match regexp %s", k, re)
+		}
+	}
+	query := r.URL.Query()
+	for k, v := range rule.Query {
+		if query.Get(k) != v {
+			return fmt.Sprintf("query %s != %s", k, v)
+		}
+	}
+	for k, re := range rule.queryRegexps {
+		if !re.MatchString(query.Get(k)) {
+			return fmt.Sprintf("query %s not match regexp %s", k, re)
+		}
+	}
+	for _, p := range rule.Body {
+		if !p.Match(body) {
+			return fmt.Sprintf("body %s %s %v not satisfied", p.Path, p.Op, p.Value)
+		}
+	}
+	return ""
+}
+
+// Match 判断json body是否满足断言
+func (p BodyPredicate) Match(body []byte) bool {
+	result := gjson.GetBytes(body, p.Path)
+	switch p.Op {
+	case OpExists:
+		return result.Exists()
+	case OpNotExists:
+		return !result.Exists()
+	case OpEq, "":
+		return result.Exists() && result.String() == fmt.Sprint(p.Value)
+	case OpNe:
+		return result.String() != fmt.Sprint(p.Value)
+	case OpRegex:
+		return result.Exists() && p.regexp != nil && p.regexp.MatchString(result.String())
+	case OpGt, OpLt:
+		value, err := strconv.ParseFloat(fmt.Sprint(p.Value), 64)
+		if err != nil || !result.Exists() {
+			return false
+		}
+		if p.Op == OpGt {
+			return result.Float() > value
+		}
+		return result.Float() < value
+	default:
+		return false
+	}
+}
+
+func compileRegexps(exprs map[string]string) (map[string]*regexp.Regexp, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]*regexp.Regexp, len(exprs))
+	for k, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "compile regexp %s for %s failed", expr, k)
+		}
+		res[k] = re
+	}
+	return res, nil
+}
+
+// RuleSelector 可以返回匹配规则的Matcher，如RuleMatcher及包装RuleMatcher的Matcher
+// NOTE: 包装RuleMatcher的Matcher需同时包装MatchRule，JournalMatcher等通过此接口获取匹配的规则名
+type RuleSelector interface {
+	Matcher
+	MatchRule(r *http.Request) (string, *Rule, error)
+}
+
+// RuleMatcher 基于请求条件的Matcher，按优先级依次判断规则，返回第一个匹配规则的ResponseMocker
+// Usage:
+// 1. 通过LoadRules从json或yaml加载规则，规则的mocker字段使用UnmarshalResponseMocker解析;
+// 2. 使用前需调用Provision初始化;
+// 3. 调试时可使用MatchRule获取匹配的规则，或使用Explain获取每条规则不匹配的原因;
+// 4. 指定scenario的规则组成状态机，用于模拟多步流程，状态按Session提取的会话隔离，使用ResetScenarios重置
+type RuleMatcher struct {
+	Extractor *eigenkey.HTTPRequestEigenkeyExtractor `json:"extractor"` // NOTE: 用于计算返回的请求特征值，为nil时仅使用path
+	Rules     []*Rule                                `json:"rules"`
+	Session   *SessionKey                            `json:"session,omitempty"` // NOTE: 场景会话标识，为nil时所有请求共享场景状态
+
+	lock      sync.RWMutex
+	writeMu   sync.Mutex // NOTE: 串行化规则的增删改及SetRules、Reload，避免热加载与管理API互相覆盖
+	stats     sync.Map   // map[ruleStatsKey]*RuleStats
+	scenarios scenarioStates
+}
+
+// LoadRules 从json或yaml加载规则列表
+func LoadRules(data []byte) ([]*Rule, error) {
+	data, err := rulesToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unmarshal rules failed")
+	}
+	return rules, nil
+}
+
+// rulesToJSON 将yaml或json格式的规则转换为json，空内容或null视为错误而不是清空规则
+// NOTE: 编辑器保存时可能先截断文件，RuleWatcher此时读到空文件，不应清空所有规则；需要清空时显式使用`[]`或`{}`
+func rulesToJSON(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrEmptyRules
+	}
+	data, err := yamlToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data, []byte("null")) {
+		return nil, ErrEmptyRules
+	}
+	return data, nil
+}
+
+// yamlToJSON 将yaml转换为json，json是yaml的子集，因此json输入同样适用
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	err := yaml.Unmarshal(data, &v)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unmarshal yaml failed")
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithMessage(err, "convert yaml to json failed")
+	}
+	return data, nil
+}
+
+// Provision 初始化
+func (m *RuleMatcher) Provision() error {
+	if m.Extractor == nil {
+		m.Extractor = &eigenkey.HTTPRequestEigenkeyExtractor{}
+	}
+	err := m.Extractor.Provision()
+	if err != nil {
+		return err
+	}
+	return m.SetRules(m.Rules)
+}
+
+// SetRules 校验并按优先级排序规则，然后整体替换，并发安全
+func (m *RuleMatcher) SetRules(rules []*Rule) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	return m.setRules(rules)
+}
+
+func (m *RuleMatcher) setRules(rules []*Rule) error {
+	sorted := make([]*Rule, len(rules))
+	copy(sorted, rules)
+	names := make(map[string]struct{}, len(sorted))
+	for _, rule := range sorted {
+		err := rule.Provision()
+		if err != nil {
+			return err
+		}
+		if rule.Name != "" {
+			if _, ok := names[rule.Name]; ok {
+				return errors.Errorf("duplicate rule name %s", rule.Name)
+			}
+			names[rule.Name] = struct{}{}
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Rules = sorted
+	return nil
+}
+
+// GetRules 返回当前规则列表的副本
+func (m *RuleMatcher) GetRules() []*Rule {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	rules := make([]*Rule, len(m.Rules))
+	copy(rules, m.Rules)
+	return rules
+}
+
+// Eigenkey 计算请求特征值，未调用Provision时返回ErrNotProvisioned
+func (m *RuleMatcher) Eigenkey(r *http.Request) (string, error) {
+	if m.Extractor == nil {
+		return "", ErrNotProvisioned
+	}
+	return m.Extractor.Eigenkey(r)
+}
+
+// Match 计算请求特征值并返回第一个匹配规则的ResponseMocker，未匹配时ResponseMocker返回nil
+func (m *RuleMatcher) Match(r *http.Request) (string, ResponseMocker, error) {
+	key, rule, err := m.MatchRule(r)
+	if err != nil || rule == nil {
+		return key, nil, err
+	}
+	return key, rule.Mocker, nil
+}
+
+// NeedBody 实现BodyMatcher，存在body条件的规则或Extractor计算特征值需要读取Body时返回true
+func (m *RuleMatcher) NeedBody(r *http.Request) bool {
+	if m.Extractor != nil && m.Extractor.NeedBody(r) {
+		return true
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, rule := range m.Rules {
+		if len(rule.Body) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRule 计算请求特征值并返回第一个匹配的规则，未匹配时规则返回nil
+// NOTE: Body超过utils.DefaultMaxBodySize时含body条件的规则不匹配，Extractor需要读取Body时特征值为空
+func (m *RuleMatcher) MatchRule(r *http.Request) (string, *Rule, error) {
+	body, tooLarge, err := m.readBody(r)
+	if err != nil {
+		return "", nil, err
+	}
+	var key string
+	if !tooLarge || m.Extractor == nil || !m.Extractor.NeedBody(r) {
+		// NOTE: 先读取Body，计算特征值可能执行ParseForm消耗application/x-www-form-urlencoded的Body
+		key, err = m.Eigenkey(r)
+		if body != nil {
+			utils.RestoreBody(r, body)
+		}
+		if err != nil {
+			return "", nil, errors.WithMessage(err, "compute eigenkey failed")
+		}
+	}
+	session := m.Session.Session(r)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, rule := range m.Rules {
+		if tooLarge && len(rule.Body) > 0 {
+			continue
+		}
+		if rule.Explain(r, body) == "" && (rule.Scenario == "" || m.scenarios.transition(session, rule)) {
+			m.ruleStats(rule).hit(key)
+			return key, rule, nil
+		}
+	}
+	return key, nil, nil
+}
+
+// readBody 需要时读取并回填请求Body，Body超过utils.DefaultMaxBodySize时tooLarge为true，r.Body仍可读取完整的原始流
+func (m *RuleMatcher) readBody(r *http.Request) (body []byte, tooLarge bool, err error) {
+	if !m.NeedBody(r) {
+		return nil, false, nil
+	}
+	body, err = utils.ReadAndRestoreBody(r)
+	if errors.Is(err, utils.ErrBodyTooLarge) {
+		return nil, true, nil
+	}
+	return body, false, err
+}
+
+// Explain 返回每条规则对请求的判断结果，key为规则名(为空时使用序号)，value为不匹配的原因，匹配时为空字符串
+func (m *RuleMatcher) Explain(r *http.Request) (map[string]string, error) {
+	body, tooLarge, err := m.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	session := m.Session.Session(r)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	results := make(map[string]string, len(m.Rules))
+	for i, rule := range m.Rules {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		reason := rule.Explain(r, body)
+		if tooLarge && len(rule.Body) > 0 {
+			reason = "body too large"
+		}
+		if reason == "" && rule.Scenario != "" && rule.RequiredState != "" {
+			if state := m.scenarios.state(session, rule.Scenario); state != rule.RequiredState {
+				reason = fmt.Sprintf("scenario %s state %s != %s", rule.Scenario, state, rule.RequiredState)
+			}
+		}
+		results[name] = reason
+	}
+	return results, nil
+}
+
+var (
+	_ Matcher      = (*RuleMatcher)(nil)
+	_ BodyMatcher  = (*RuleMatcher)(nil)
+	_ RuleSelector = (*RuleMatcher)(nil)
+)
@@ -0,0 +1,182 @@
+package mock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRuleNotFound 规则不存在
+	ErrRuleNotFound = errors.New("rule not found")
+
+	// ErrRuleExists 规则已存在
+	ErrRuleExists = errors.New("rule already exists")
+)
+
+// MaxLastEigenkeys RuleStats保留的最近匹配的请求特征值个数
+var MaxLastEigenkeys = 10
+
+// ruleSeq 生成规则id
+var ruleSeq uint64
+
+// RuleStats 规则命中统计
+type RuleStats struct {
+	Hits          int64     `json:"hits"`
+	LastMatchedAt time.Time `json:"last_matched_at,omitempty"`
+	LastEigenkeys []string  `json:"last_eigenkeys,omitempty"` // NOTE: 最近在前
+
+	lock sync.Mutex
+}
+
+func (s *RuleStats) hit(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Hits++
+	s.LastMatchedAt = time.Now()
+	s.LastEigenkeys = append([]string{key}, s.LastEigenkeys...)
+	if len(s.LastEigenkeys) > MaxLastEigenkeys {
+		s.LastEigenkeys = s.LastEigenkeys[:MaxLastEigenkeys]
+	}
+}
+
+func (s *RuleStats) snapshot() *RuleStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return &RuleStats{
+		Hits:          s.Hits,
+		LastMatchedAt: s.LastMatchedAt,
+		LastEigenkeys: append([]string(nil), s.LastEigenkeys...),
+	}
+}
+
+// ruleStatsKey 命名规则按名称记录，更新或启停后统计保留；未命名规则按Provision生成的id记录
+type ruleStatsKey struct {
+	name string
+	id   uint64
+}
+
+func statsKeyOf(rule *Rule) ruleStatsKey {
+	if rule.Name != "" {
+		return ruleStatsKey{name: rule.Name}
+	}
+	return ruleStatsKey{id: rule.id}
+}
+
+func (m *RuleMatcher) ruleStats(rule *Rule) *RuleStats {
+	stats, _ := m.stats.LoadOrStore(statsKeyOf(rule), new(RuleStats))
+	return stats.(*RuleStats)
+}
+
+func (m *RuleMatcher) loadStats(key ruleStatsKey) *RuleStats {
+	stats, ok := m.stats.Load(key)
+	if !ok {
+		return &RuleStats{}
+	}
+	return stats.(*RuleStats).snapshot()
+}
+
+// Stats 返回命名规则的命中统计快照，未命中过时返回空统计，不会新建统计条目
+func (m *RuleMatcher) Stats(name string) *RuleStats {
+	return m.loadStats(ruleStatsKey{name: name})
+}
+
+// StatsOf 返回规则的命中统计快照，适用于未命名的规则
+func (m *RuleMatcher) StatsOf(rule *Rule) *RuleStats {
+	return m.loadStats(statsKeyOf(rule))
+}
+
+// ResetStats 重置所有规则的命中统计
+func (m *RuleMatcher) ResetStats() {
+	m.stats.Range(func(k, _ interface{}) bool {
+		m.stats.Delete(k)
+		return true
+	})
+}
+
+// GetRule 根据名称获取规则
+func (m *RuleMatcher) GetRule(name string) (*Rule, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, rule := range m.Rules {
+		if rule.Name == name {
+			return rule, nil
+		}
+	}
+	return nil, errors.WithMessagef(ErrRuleNotFound, "get rule %s", name)
+}
+
+// AddRule 新增规则，规则名不能为空且不能重复
+func (m *RuleMatcher) AddRule(rule *Rule) error {
+	if rule.Name == "" {
+		return errors.New("rule name should not be empty")
+	}
+	return m.updateRules(func(rules []*Rule) ([]*Rule, error) {
+		if indexOfRule(rules, rule.Name) >= 0 {
+			return nil, errors.WithMessagef(ErrRuleExists, "add rule %s", rule.Name)
+		}
+		return append(rules, rule), nil
+	})
+}
+
+// UpdateRule 替换同名规则
+func (m *RuleMatcher) UpdateRule(rule *Rule) error {
+	return m.updateRules(func(rules []*Rule) ([]*Rule, error) {
+		i := indexOfRule(rules, rule.Name)
+		if i < 0 {
+			return nil, errors.WithMessagef(ErrRuleNotFound, "update rule %s", rule.Name)
+		}
+		rules[i] = rule
+		return rules, nil
+	})
+}
+
+// DeleteRule 删除规则及其命中统计
+func (m *RuleMatcher) DeleteRule(name string) error {
+	err := m.updateRules(func(rules []*Rule) ([]*Rule, error) {
+		i := indexOfRule(rules, name)
+		if i < 0 {
+			return nil, errors.WithMessagef(ErrRuleNotFound, "delete rule %s", name)
+		}
+		return append(rules[:i], rules[i+1:]...), nil
+	})
+	if err == nil {
+		m.stats.Delete(ruleStatsKey{name: name})
+	}
+	return err
+}
+
+// EnableRule 启用或禁用规则
+func (m *RuleMatcher) EnableRule(name string, enabled bool) error {
+	return m.updateRules(func(rules []*Rule) ([]*Rule, error) {
+		i := indexOfRule(rules, name)
+		if i < 0 {
+			return nil, errors.WithMessagef(ErrRuleNotFound, "enable rule %s", name)
+		}
+		rule := *rules[i] // NOTE: 复制后修改，避免影响进行中的匹配
+		rule.Disabled = !enabled
+		rules[i] = &rule
+		return rules, nil
+	})
+}
+
+// updateRules 基于当前规则副本修改，然后整体替换，与SetRules、Reload串行执行
+func (m *RuleMatcher) updateRules(fn func([]*Rule) ([]*Rule, error)) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	rules, err := fn(m.GetRules())
+	if err != nil {
+		return err
+	}
+	return m.setRules(rules)
+}
+
+func indexOfRule(rules []*Rule, name string) int {
+	for i, rule := range rules {
+		if rule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,240 @@
+package mock_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+	"github.com/ccmonky/pkg/utils"
+)
+
+const testRulesYAML = `
+- name: vip-order
+  priority: 10
+  methods: [POST]
+  path: /orders
+  headers:
+    X-Tenant: acme
+  body:
+    - path: user.level
+      op: gt
+      value: 3
+    - path: items.#
+      value: 2
+  mocker:
+    response_mocker: ResponseMockerBuilder
+    status_code: 201
+    body: vip
+- name: order
+  methods: [post]
+  path_regexp: ^/orders/?$
+  mocker:
+    response_mocker: ResponseMockerBuilder
+    status_code: 200
+    body: normal
+- name: user
+  path: /users/*
+  query_regexps:
+    fields: ^(id|name)$
+  mocker: {}
+`
+
+func TestRuleMatcher(t *testing.T) {
+	rules, err := mock.LoadRules([]byte(testRulesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := &mock.RuleMatcher{Rules: rules}
+	err = matcher.Provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		method, url, tenant, body string
+		rule                      string
+		respBody                  string
+	}{
+		{"POST", "/orders", "acme", `{"user":{"level":5},"items":[1,2]}`, "vip-order", "vip"},
+		{"POST", "/orders", "other", `{"user":{"level":5},"items":[1,2]}`, "order", "normal"},
+		{"POST", "/orders/", "acme", `{"user":{"level":1},"items":[1,2]}`, "order", "normal"},
+		{"GET", "/users/1?fields=id", "", "", "user", ""},
+		{"GET", "/users/1?fields=email", "", "", "", ""},
+		{"GET", "/users/1/orders", "", "", "", ""},
+	}
+	for _, tc := range cases {
+		rq, _ := http.NewRequest(tc.method, "http://localhost"+tc.url, strings.NewReader(tc.body))
+		rq.Header.Set("X-Tenant", tc.tenant)
+		_, rule, err := matcher.MatchRule(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.rule == "" {
+			if rule != nil {
+				t.Fatalf("%s %s should not match, got %s", tc.method, tc.url, rule.Name)
+			}
+			continue
+		}
+		if rule == nil || rule.Name != tc.rule {
+			explain, _ := matcher.Explain(rq)
+			t.Fatalf("%s %s should match %s, explain: %v", tc.method, tc.url, tc.rule, explain)
+		}
+		_, mocker, _ := matcher.Match(rq)
+		if mocker.IsTransparent() {
+			continue
+		}
+		rp, err := mocker.Mock(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rp.Body)
+		if string(body) != tc.respBody {
+			t.Fatalf("should ==, got %s", body)
+		}
+		reqBody, _ := ioutil.ReadAll(rq.Body)
+		if string(reqBody) != tc.body {
+			t.Fatal("request body should be restored")
+		}
+	}
+
+	// NOTE: 表单Content-Type的Body会被计算特征值时的ParseForm消耗，Body断言仍应生效
+	form := `{"user":{"level":5},"items":[1,2]}`
+	rq, _ := http.NewRequest("POST", "http://localhost/orders", strings.NewReader(form))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rq.Header.Set("X-Tenant", "acme")
+	_, rule, err := matcher.MatchRule(rq)
+	if err != nil || rule == nil || rule.Name != "vip-order" {
+		t.Fatal("form post should match body rule", err, rule)
+	}
+	if reqBody, _ := ioutil.ReadAll(rq.Body); string(reqBody) != form {
+		t.Fatal("form body should be restored")
+	}
+
+	rq, _ = http.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{}`))
+	explain, err := matcher.Explain(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain["order"] != "" || !strings.Contains(explain["vip-order"], "X-Tenant") {
+		t.Fatalf("unexpected explain %v", explain)
+	}
+
+	_, err = mock.LoadRules([]byte(`[{"name": "bad", "mocker": {"response_mocker": "NotExists"}}]`))
+	if err == nil {
+		t.Fatal("should fail for unknown mocker")
+	}
+	err = matcher.SetRules([]*mock.Rule{{Name: "bad", PathRegexp: "("}})
+	if err == nil {
+		t.Fatal("should fail for invalid regexp")
+	}
+	if len(matcher.GetRules()) != 3 {
+		t.Fatal("rules should be kept on error")
+	}
+}
+
+func TestRuleMatcherBody(t *testing.T) {
+	rules, err := mock.LoadRules([]byte(testRulesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := &mock.RuleMatcher{Rules: rules}
+	err = matcher.Provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int64) { utils.DefaultMaxBodySize = n }(utils.DefaultMaxBodySize)
+	utils.DefaultMaxBodySize = 4
+
+	// NOTE: Body超过限制时含body条件的规则不匹配，其他规则仍可匹配
+	body := `{"user":{"level":5},"items":[1,2]}`
+	rq, _ := http.NewRequest("POST", "http://localhost/orders", strings.NewReader(body))
+	rq.Header.Set("X-Tenant", "acme")
+	_, rule, err := matcher.MatchRule(rq)
+	if err != nil || rule == nil || rule.Name != "order" {
+		t.Fatal("too large body should skip body rule", err, rule)
+	}
+	if reqBody, _ := ioutil.ReadAll(rq.Body); string(reqBody) != body {
+		t.Fatal("original body should be kept")
+	}
+	rq, _ = http.NewRequest("POST", "http://localhost/orders", strings.NewReader(body))
+	rq.Header.Set("X-Tenant", "acme")
+	explain, err := matcher.Explain(rq)
+	if err != nil || explain["vip-order"] != "body too large" {
+		t.Fatal("explain should report body too large", err, explain)
+	}
+
+	// NOTE: 没有body条件的规则时不读取Body
+	err = matcher.SetRules(rules[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := ioutil.NopCloser(strings.NewReader(body))
+	rq, _ = http.NewRequest("POST", "http://localhost/orders", reader)
+	_, rule, err = matcher.MatchRule(rq)
+	if err != nil || rule == nil || rule.Name != "order" {
+		t.Fatal("should match order", err, rule)
+	}
+	if rq.Body != reader {
+		t.Fatal("body should not be read")
+	}
+}
+
+func TestRuleMatcherStats(t *testing.T) {
+	rules, err := mock.LoadRules([]byte(`
+- name: a
+  path: /a
+  mocker: {}
+- path: /b
+  mocker: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := &mock.RuleMatcher{Rules: rules}
+	if err = matcher.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a", "/b", "/b"} {
+		rq, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if _, rule, _ := matcher.MatchRule(rq); rule == nil {
+			t.Fatalf("%s should match", path)
+		}
+	}
+	if matcher.Stats("a").Hits != 1 {
+		t.Fatal("named rule should be counted")
+	}
+	unnamed := matcher.GetRules()[1]
+	if stats := matcher.StatsOf(unnamed); stats.Hits != 2 || stats.LastEigenkeys[0] != "/b" {
+		t.Fatal("unnamed rule should be counted", stats)
+	}
+	if matcher.Stats("").Hits != 0 || matcher.Stats("missing").Hits != 0 {
+		t.Fatal("unknown rule should have empty stats")
+	}
+	if err = matcher.EnableRule("a", false); err != nil {
+		t.Fatal(err)
+	}
+	if matcher.Stats("a").Hits != 1 {
+		t.Fatal("stats should be kept after disabling rule")
+	}
+
+	// NOTE: 热加载与管理API串行执行，最终规则为两者之一的结果，且不会死锁
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := matcher.Reload([]byte(`[{"name": "a", "path": "/a", "mocker": {}}]`)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		matcher.EnableRule("a", i%2 == 0)
+	}
+	<-done
+	if rules := matcher.GetRules(); len(rules) != 1 || rules[0].Name != "a" {
+		t.Fatal(rules)
+	}
+}
@@ -0,0 +1,181 @@
+package mock
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/ccmonky/pkg/baggage"
+)
+
+// ScenarioStarted 场景的初始状态
+const ScenarioStarted = "started"
+
+// SessionKey 从请求中提取场景会话标识，不同会话的场景状态相互独立，便于并行测试
+// NOTE: 同时指定时优先使用Header，均未指定或提取为空时所有请求共享空会话
+type SessionKey struct {
+	Header  string `json:"header,omitempty"`  // NOTE: 请求头，如X-Mock-Session
+	Baggage string `json:"baggage,omitempty"` // NOTE: baggage前缀，如x-mock，与Attr一起使用
+	Attr    string `json:"attr,omitempty"`    // NOTE: baggage属性，如session，即请求头X-Mock-Session或参数x-mock-session
+}
+
+// Session 提取请求的会话标识，sk为nil时返回空字符串
+func (sk *SessionKey) Session(r *http.Request) string {
+	if sk == nil {
+		return ""
+	}
+	if sk.Header != "" {
+		if session := r.Header.Get(sk.Header); session != "" {
+			return session
+		}
+	}
+	if sk.Attr == "" {
+		return ""
+	}
+	// NOTE: baggage会解析Form，使用不带Body的副本避免消耗请求Body，因此只支持请求头和query参数
+	rc := r.Clone(r.Context())
+	rc.Body = http.NoBody
+	return baggage.New(sk.Baggage).Extract(rc).Attr(sk.Attr).String()
+}
+
+// MaxScenarioSessions 保存场景状态的会话个数上限，超过时淘汰最久未使用的会话，避免客户端通过会话标识无限占用内存
+var MaxScenarioSessions = 10000
+
+// scenarioStates 按会话保存的场景状态，按最近使用顺序淘汰超过MaxScenarioSessions的会话
+type scenarioStates struct {
+	lock     sync.Mutex
+	sessions map[string]*list.Element // NOTE: value为*scenarioSession
+	lru      list.List                // NOTE: 最近使用的会话在前
+}
+
+// scenarioSession 一个会话的场景状态，map[scenario]state
+type scenarioSession struct {
+	session string
+	states  map[string]string
+}
+
+// transition 判断规则是否满足场景的当前状态，满足时转移到规则的NewState
+func (ss *scenarioStates) transition(session string, rule *Rule) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if rule.RequiredState != "" && ss.get(session, rule.Scenario) != rule.RequiredState {
+		return false
+	}
+	if rule.NewState != "" {
+		ss.set(session, rule.Scenario, rule.NewState)
+	}
+	return true
+}
+
+func (ss *scenarioStates) state(session, scenario string) string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.get(session, scenario)
+}
+
+func (ss *scenarioStates) setState(session, scenario, state string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.set(session, scenario, state)
+}
+
+func (ss *scenarioStates) get(session, scenario string) string {
+	if e, ok := ss.sessions[session]; ok {
+		ss.lru.MoveToFront(e)
+		if state, ok := e.Value.(*scenarioSession).states[scenario]; ok {
+			return state
+		}
+	}
+	return ScenarioStarted
+}
+
+func (ss *scenarioStates) set(session, scenario, state string) {
+	if e, ok := ss.sessions[session]; ok {
+		ss.lru.MoveToFront(e)
+		e.Value.(*scenarioSession).states[scenario] = state
+		return
+	}
+	if ss.sessions == nil {
+		ss.sessions = make(map[string]*list.Element)
+	}
+	for MaxScenarioSessions > 0 && ss.lru.Len() >= MaxScenarioSessions {
+		ss.remove(ss.lru.Back())
+	}
+	ss.sessions[session] = ss.lru.PushFront(&scenarioSession{
+		session: session,
+		states:  map[string]string{scenario: state},
+	})
+}
+
+func (ss *scenarioStates) remove(e *list.Element) {
+	ss.lru.Remove(e)
+	delete(ss.sessions, e.Value.(*scenarioSession).session)
+}
+
+func (ss *scenarioStates) all() map[string]map[string]string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	res := make(map[string]map[string]string, len(ss.sessions))
+	for session, e := range ss.sessions {
+		states := e.Value.(*scenarioSession).states
+		res[session] = make(map[string]string, len(states))
+		for scenario, state := range states {
+			res[session][scenario] = state
+		}
+	}
+	return res
+}
+
+func (ss *scenarioStates) reset(sessions ...string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if len(sessions) == 0 {
+		ss.sessions = nil
+		ss.lru.Init()
+		return
+	}
+	for _, session := range sessions {
+		if e, ok := ss.sessions[session]; ok {
+			ss.remove(e)
+		}
+	}
+}
+
+// Scenarios 返回规则中定义的所有场景名
+func (m *RuleMatcher) Scenarios() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	names := make(map[string]struct{})
+	for _, rule := range m.Rules {
+		if rule.Scenario != "" {
+			names[rule.Scenario] = struct{}{}
+		}
+	}
+	scenarios := make([]string, 0, len(names))
+	for name := range names {
+		scenarios = append(scenarios, name)
+	}
+	sort.Strings(scenarios)
+	return scenarios
+}
+
+// ScenarioState 返回会话中场景的当前状态，未转移过时为ScenarioStarted
+func (m *RuleMatcher) ScenarioState(session, scenario string) string {
+	return m.scenarios.state(session, scenario)
+}
+
+// SetScenarioState 设置会话中场景的状态，用于测试中直接跳到某一步
+func (m *RuleMatcher) SetScenarioState(session, scenario, state string) {
+	m.scenarios.setState(session, scenario, state)
+}
+
+// ScenarioStates 返回所有会话中发生过转移的场景状态，map[session]map[scenario]state
+func (m *RuleMatcher) ScenarioStates() map[string]map[string]string {
+	return m.scenarios.all()
+}
+
+// ResetScenarios 将指定会话的所有场景重置为ScenarioStarted，未指定会话时重置所有会话
+func (m *RuleMatcher) ResetScenarios(sessions ...string) {
+	m.scenarios.reset(sessions...)
+}
@@ -0,0 +1,175 @@
+package mock_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+const orderScenario = `
+- name: create
+  scenario: order
+  methods: [POST]
+  path: /order
+  new_state: pending-1
+  mocker: {response_mocker: ResponseMockerBuilder, status_code: 201, body: created}
+- name: pending-1
+  scenario: order
+  methods: [GET]
+  path: /order/*
+  required_state: pending-1
+  new_state: pending-2
+  mocker: {response_mocker: ResponseMockerBuilder, body: pending}
+- name: pending-2
+  scenario: order
+  methods: [GET]
+  path: /order/*
+  required_state: pending-2
+  new_state: paid
+  mocker: {response_mocker: ResponseMockerBuilder, body: pending}
+- name: paid
+  scenario: order
+  methods: [GET]
+  path: /order/*
+  required_state: paid
+  mocker: {response_mocker: ResponseMockerBuilder, body: paid}
+`
+
+func newScenarioMatcher(t *testing.T) *mock.RuleMatcher {
+	rules, err := mock.LoadRules([]byte(orderScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := &mock.RuleMatcher{
+		Rules:   rules,
+		Session: &mock.SessionKey{Header: "X-Session", Baggage: "x-mock", Attr: "session"},
+	}
+	if err := matcher.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	return matcher
+}
+
+func TestScenario(t *testing.T) {
+	matcher := newScenarioMatcher(t)
+	ts := httptest.NewServer(mock.NewHandler(matcher, nil))
+	defer ts.Close()
+	call := func(method, path string, header http.Header) string {
+		rq, _ := http.NewRequest(method, ts.URL+path, nil)
+		for k := range header {
+			rq.Header.Set(k, header.Get(k))
+		}
+		rp, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rp.Body.Close()
+		if rp.StatusCode == http.StatusNotFound {
+			return "404"
+		}
+		body, _ := ioutil.ReadAll(rp.Body)
+		return string(body)
+	}
+
+	a := http.Header{"X-Session": []string{"a"}}
+	if got := call("GET", "/order/1", a); got != "404" {
+		t.Fatalf("should not match before created, got %s", got)
+	}
+	if got := call("POST", "/order", a); got != "created" {
+		t.Fatal(got)
+	}
+	for _, want := range []string{"pending", "pending", "paid", "paid"} {
+		if got := call("GET", "/order/1", a); got != want {
+			t.Fatalf("should be %s, got %s", want, got)
+		}
+	}
+
+	// NOTE: 不同会话的状态相互独立，baggage属性同样可作为会话标识
+	b := http.Header{"X-Mock-Session": []string{"b"}}
+	if got := call("GET", "/order/1", b); got != "404" {
+		t.Fatalf("session b should be independent, got %s", got)
+	}
+	call("POST", "/order", b)
+	if got := call("GET", "/order/1", b); got != "pending" {
+		t.Fatal(got)
+	}
+	if matcher.ScenarioState("a", "order") != "paid" || matcher.ScenarioState("b", "order") != "pending-2" {
+		t.Fatal(matcher.ScenarioStates())
+	}
+
+	matcher.ResetScenarios("a")
+	if matcher.ScenarioState("a", "order") != mock.ScenarioStarted || matcher.ScenarioState("b", "order") != "pending-2" {
+		t.Fatal(matcher.ScenarioStates())
+	}
+	matcher.SetScenarioState("a", "order", "paid")
+	if got := call("GET", "/order/1", a); got != "paid" {
+		t.Fatal(got)
+	}
+	matcher.ResetScenarios()
+	if len(matcher.ScenarioStates()) != 0 {
+		t.Fatal("should reset all sessions")
+	}
+
+	// NOTE: 会话个数超过MaxScenarioSessions时淘汰最久未使用的会话
+	defer func(n int) { mock.MaxScenarioSessions = n }(mock.MaxScenarioSessions)
+	mock.MaxScenarioSessions = 2
+	for _, session := range []string{"a", "b"} {
+		call("POST", "/order", http.Header{"X-Session": []string{session}})
+	}
+	call("GET", "/order/1", a)
+	call("POST", "/order", http.Header{"X-Session": []string{"c"}})
+	states := matcher.ScenarioStates()
+	if len(states) != 2 || states["a"]["order"] != "pending-2" || states["b"] != nil {
+		t.Fatalf("least recently used session should be evicted, got %v", states)
+	}
+
+	rq, _ := http.NewRequest("GET", "http://localhost/order/1", nil)
+	explain, err := matcher.Explain(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain["paid"] != "scenario order state started != paid" {
+		t.Fatalf("unexpected explain %v", explain)
+	}
+
+	err = matcher.SetRules([]*mock.Rule{{Name: "bad", NewState: "x"}})
+	if err == nil {
+		t.Fatal("should fail for states without scenario")
+	}
+}
+
+func TestAdminScenarios(t *testing.T) {
+	matcher := newScenarioMatcher(t)
+	admin := mock.NewAdminHandler(matcher)
+	call := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := call("PUT", "/scenarios/order", `{"session": "a", "state": "paid"}`); code != 204 {
+		t.Fatal(code, body)
+	}
+	if code, _ := call("PUT", "/scenarios/order", `{"session": "a"}`); code != 400 {
+		t.Fatal("empty state should be 400")
+	}
+	matcher.SetScenarioState("b", "order", "pending-1")
+	code, body := call("GET", "/scenarios", "")
+	if code != 200 || body != `{"scenarios":["order"],"sessions":{"a":{"order":"paid"},"b":{"order":"pending-1"}}}` {
+		t.Fatal(code, body)
+	}
+	if code, _ := call("DELETE", "/scenarios?session=a", ""); code != 204 {
+		t.Fatal(code)
+	}
+	if matcher.ScenarioState("a", "order") != mock.ScenarioStarted || matcher.ScenarioState("b", "order") != "pending-1" {
+		t.Fatal(matcher.ScenarioStates())
+	}
+	call("DELETE", "/scenarios", "")
+	if matcher.ScenarioState("b", "order") != mock.ScenarioStarted {
+		t.Fatal("should reset all sessions")
+	}
+}
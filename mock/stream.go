@@ -0,0 +1,179 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// 流式响应类型
+const (
+	StreamChunked = "chunked" // 分块传输，每个chunk的data原样写出
+	StreamSSE     = "sse"     // Server-Sent Events，每个chunk为一个事件
+	StreamNDJSON  = "ndjson"  // 换行分隔的json，每个chunk为一行
+)
+
+// Stream 流式响应配置，每个chunk写出后立即flush
+type Stream struct {
+	Type   string         `json:"type"`            // NOTE: chunked、sse或ndjson，默认chunked
+	Delay  utils.Duration `json:"delay,omitempty"` // NOTE: 每个chunk写出前的延迟
+	Chunks []*StreamChunk `json:"chunks"`
+}
+
+// StreamChunk 流式响应的一个chunk
+type StreamChunk struct {
+	Delay *utils.Duration `json:"delay,omitempty"` // NOTE: 覆盖Stream.Delay
+	Data  string          `json:"data,omitempty"`  // NOTE: chunked为原始内容，sse为data字段(多行时拆为多个data)，ndjson为一行内容
+	JSON  json.RawMessage `json:"json,omitempty"`  // NOTE: ndjson和sse可用，压缩为一行后作为data
+	ID    string          `json:"id,omitempty"`    // NOTE: sse事件id
+	Event string          `json:"event,omitempty"` // NOTE: sse事件类型
+	Retry int             `json:"retry,omitempty"` // NOTE: sse重连间隔，毫秒
+}
+
+// ContentType 流式响应默认的Content-Type，chunked返回空字符串
+func (s *Stream) ContentType() string {
+	switch s.Type {
+	case StreamSSE:
+		return "text/event-stream"
+	case StreamNDJSON:
+		return "application/x-ndjson"
+	default:
+		return ""
+	}
+}
+
+// Encode 按Type编码所有chunk，render不为nil时先渲染data
+func (s *Stream) Encode(render func(string) (string, error)) ([][]byte, error) {
+	switch s.Type {
+	case "", StreamChunked, StreamSSE, StreamNDJSON:
+	default:
+		return nil, errors.Errorf("unknown stream type %s", s.Type)
+	}
+	chunks := make([][]byte, len(s.Chunks))
+	for i, chunk := range s.Chunks {
+		data, err := chunk.text()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "compact json of chunk %d failed", i)
+		}
+		if render != nil {
+			data, err = render(data)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "render chunk %d failed", i)
+			}
+		}
+		switch s.Type {
+		case StreamSSE:
+			chunks[i] = chunk.encodeSSE(data)
+		case StreamNDJSON:
+			if strings.ContainsAny(data, "\r\n") {
+				return nil, errors.Errorf("ndjson chunk %d should be a single line", i)
+			}
+			chunks[i] = []byte(data + "\n")
+		default:
+			chunks[i] = []byte(data)
+		}
+	}
+	return chunks, nil
+}
+
+// text 返回chunk渲染前的data，指定JSON时为压缩为一行的json
+func (c *StreamChunk) text() (string, error) {
+	if len(c.JSON) == 0 {
+		return c.Data, nil
+	}
+	var buf bytes.Buffer
+	err := json.Compact(&buf, c.JSON)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *StreamChunk) encodeSSE(data string) []byte {
+	var buf bytes.Buffer
+	if c.ID != "" {
+		buf.WriteString("id: " + c.ID + "\n")
+	}
+	if c.Event != "" {
+		buf.WriteString("event: " + c.Event + "\n")
+	}
+	if c.Retry > 0 {
+		buf.WriteString("retry: " + strconv.Itoa(c.Retry) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// Body 生成流式响应Body，每个chunk按延迟逐个读出，ctx结束时返回ctx.Err()
+func (s *Stream) Body(ctx context.Context, chunks [][]byte) io.ReadCloser {
+	delays := make([]time.Duration, len(s.Chunks))
+	for i, chunk := range s.Chunks {
+		delays[i] = s.Delay.Duration
+		if chunk.Delay != nil {
+			delays[i] = chunk.Delay.Duration
+		}
+	}
+	return &streamBody{ctx: ctx, chunks: chunks, delays: delays}
+}
+
+// streamBody 逐个读出chunk，每次Read最多返回一个chunk，以便写出方逐个flush
+type streamBody struct {
+	ctx     context.Context
+	chunks  [][]byte
+	delays  []time.Duration
+	next    int
+	current []byte
+	closed  bool
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("read on closed stream body")
+	}
+	for len(b.current) == 0 {
+		if b.next >= len(b.chunks) {
+			return 0, io.EOF
+		}
+		if delay := b.delays[b.next]; delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-b.ctx.Done():
+				timer.Stop()
+				return 0, b.ctx.Err()
+			}
+		}
+		b.current = b.chunks[b.next]
+		b.next++
+	}
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+	return n, nil
+}
+
+func (b *streamBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// isStreaming 判断是否为需要逐块flush的流式响应
+func isStreaming(rp *http.Response) bool {
+	for _, te := range rp.TransferEncoding {
+		if te == "chunked" {
+			return true
+		}
+	}
+	return false
+}
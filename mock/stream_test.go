@@ -0,0 +1,123 @@
+package mock_test
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestStreamResponseMocker(t *testing.T) {
+	matcher := newEigenkeyMatcher(t, nil, map[string]string{
+		"/sse": `{
+			"response_mocker": "ResponseMockerBuilder",
+			"status_code": 200,
+			"template": true,
+			"stream": {
+				"type": "sse",
+				"chunks": [
+					{"id": "1", "event": "start", "retry": 1000, "data": "{{.Query.Get \"q\"}}"},
+					{"id": "2", "json": {"a": 1, "b": [1, 2]}},
+					{"data": "line1\nline2"}
+				]
+			}
+		}`,
+		"/ndjson": `{
+			"response_mocker": "ResponseMockerBuilder",
+			"status_code": 200,
+			"stream": {
+				"type": "ndjson",
+				"chunks": [{"json": {"n": 1}}, {"data": "{\"n\": 2}"}]
+			}
+		}`,
+		"/chunked": `{
+			"response_mocker": "ResponseMockerBuilder",
+			"status_code": 200,
+			"header": {"Content-Type": ["text/plain"]},
+			"stream": {
+				"delay": "100ms",
+				"chunks": [{"delay": "0s", "data": "first"}, {"data": "second"}]
+			}
+		}`,
+	})
+
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	cases := []struct {
+		url, contentType, body string
+	}{
+		{"http://localhost/sse?q=hello", "text/event-stream", "id: 1\nevent: start\nretry: 1000\ndata: hello\n\nid: 2\ndata: {\"a\":1,\"b\":[1,2]}\n\ndata: line1\ndata: line2\n\n"},
+		{"http://localhost/ndjson", "application/x-ndjson", "{\"n\":1}\n{\"n\": 2}\n"},
+		{"http://localhost/chunked", "text/plain", "firstsecond"},
+	}
+	for _, c := range cases {
+		rp, err := client.Get(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(rp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != c.body || rp.Header.Get("Content-Type") != c.contentType {
+			t.Fatalf("%s got %q %v", c.url, body, rp.Header)
+		}
+	}
+
+	// handler flushes each chunk before the next delay elapses
+	ts := httptest.NewServer(mock.NewHandler(matcher, nil))
+	defer ts.Close()
+	start := time.Now()
+	rp, err := http.Get(ts.URL + "/chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Body.Close()
+	if len(rp.TransferEncoding) == 0 || rp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("should be chunked, got %v", rp.TransferEncoding)
+	}
+	reader := bufio.NewReader(rp.Body)
+	first := make([]byte, 5)
+	if _, err := reader.Read(first); err != nil || string(first) != "first" {
+		t.Fatalf("got %q, %v", first, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("first chunk should be flushed immediately, got %v", elapsed)
+	}
+	rest, _ := ioutil.ReadAll(reader)
+	if string(rest) != "second" || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("got %q after %v", rest, time.Since(start))
+	}
+}
+
+func TestStreamResponseMockerCancel(t *testing.T) {
+	matcher := newEigenkeyMatcher(t, nil, map[string]string{
+		"/slow": `{
+			"response_mocker": "ResponseMockerBuilder",
+			"status_code": 200,
+			"stream": {"delay": "1h", "chunks": [{"delay": "0s", "data": "a"}, {"data": "b"}]}
+		}`,
+		"/invalid": `{"response_mocker": "ResponseMockerBuilder", "status_code": 200, "stream": {"type": "ndjson", "chunks": [{"data": "a\nb"}]}}`,
+	})
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rq, _ := http.NewRequestWithContext(ctx, "GET", "http://localhost/slow", nil)
+	rp, err := client.Do(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rp.Body)
+	if err == nil || string(body) != "a" {
+		t.Fatalf("should stop on context done, got %q, %v", body, err)
+	}
+
+	_, err = client.Get("http://localhost/invalid")
+	if err == nil {
+		t.Fatal("multi-line ndjson chunk should fail")
+	}
+}
@@ -0,0 +1,149 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// TemplateFuncs ResponseMockerBuilder模板可用的辅助函数
+var TemplateFuncs = template.FuncMap{
+	"ulid":         utils.Ulid,
+	"randomString": utils.RandomString,
+	"random":       random,
+	"now":          time.Now,
+	"gjson": func(json, path string) string {
+		return gjson.Get(json, path).String()
+	},
+}
+
+// random 返回[min, max)内的随机整数，max<=min时返回错误而不是panic
+func random(min, max int) (int, error) {
+	if max <= min {
+		return 0, errors.Errorf("random requires min < max, got [%d, %d)", min, max)
+	}
+	return utils.Random(min, max), nil
+}
+
+// TemplateData ResponseMockerBuilder模板渲染时使用的请求数据
+// Usage:
+// 1. `{{.Path}}`, `{{.Query.Get "id"}}`, `{{.Header.Get "X-Request-Id"}}`;
+// 2. `{{.JSON.Get "user.id"}}`获取json body字段，或`{{gjson .Body "user.id"}}`;
+// 3. `{{ulid}}`, `{{randomString 8}}`, `{{random 1 100}}`, `{{now.Unix}}`
+type TemplateData struct {
+	Method   string
+	Host     string
+	Path     string
+	Query    url.Values
+	Header   http.Header
+	Body     string
+	JSON     gjson.Result
+	Eigenkey string
+}
+
+// NewTemplateData 根据请求生成TemplateData，会读取并回填r.Body
+func NewTemplateData(r *http.Request) (*TemplateData, error) {
+	data := &TemplateData{
+		Method: r.Method,
+		Host:   r.Host,
+		Header: r.Header,
+	}
+	if r.URL != nil {
+		data.Path = r.URL.Path
+		data.Query = r.URL.Query()
+	}
+	data.Eigenkey, _ = EigenkeyFromContext(r.Context())
+	body, err := utils.ReadAndRestoreBody(r)
+	if err != nil {
+		return nil, err
+	}
+	data.Body = string(body)
+	if gjson.Valid(data.Body) {
+		data.JSON = gjson.Parse(data.Body)
+	}
+	return data, nil
+}
+
+// UnmarshalJSON 解析json，Template为true时预先解析Body和Header的模板，模板语法错误在加载时报错
+func (mr *ResponseMockerBuilder) UnmarshalJSON(data []byte) error {
+	type plain ResponseMockerBuilder
+	err := json.Unmarshal(data, (*plain)(mr))
+	if err != nil {
+		return err
+	}
+	return mr.parseTemplates()
+}
+
+// parseTemplates 解析并缓存模板，key为模板文本
+func (mr *ResponseMockerBuilder) parseTemplates() error {
+	mr.templates = nil
+	if !mr.Template {
+		return nil
+	}
+	texts := []string{mr.Body}
+	for _, vs := range mr.Header {
+		texts = append(texts, vs...)
+	}
+	if mr.Stream != nil {
+		for i, chunk := range mr.Stream.Chunks {
+			text, err := chunk.text()
+			if err != nil {
+				return errors.WithMessagef(err, "compact json of chunk %d failed", i)
+			}
+			texts = append(texts, text)
+		}
+	}
+	mr.templates = make(map[string]*template.Template, len(texts))
+	for _, text := range texts {
+		if _, ok := mr.templates[text]; ok {
+			continue
+		}
+		tmpl, err := template.New("mock").Funcs(TemplateFuncs).Parse(text)
+		if err != nil {
+			return errors.WithMessagef(err, "parse template %q failed", text)
+		}
+		mr.templates[text] = tmpl
+	}
+	return nil
+}
+
+// render 渲染模板，优先使用解析json时缓存的模板，未缓存的(如代码中构造或fixture文件的内容)在渲染时解析
+func (mr ResponseMockerBuilder) render(text string, data *TemplateData) (string, error) {
+	tmpl, ok := mr.templates[text]
+	if !ok {
+		var err error
+		tmpl, err = template.New("mock").Funcs(TemplateFuncs).Parse(text)
+		if err != nil {
+			return "", err
+		}
+	}
+	var buf strings.Builder
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderHeader 将header的每个值作为模板渲染
+func (mr ResponseMockerBuilder) renderHeader(header http.Header, data *TemplateData) (http.Header, error) {
+	rendered := make(http.Header, len(header))
+	for k, vs := range header {
+		for _, v := range vs {
+			rv, err := mr.render(v, data)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "render header %s failed", k)
+			}
+			rendered[k] = append(rendered[k], rv)
+		}
+	}
+	return rendered, nil
+}
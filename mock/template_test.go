@@ -0,0 +1,70 @@
+package mock_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+func TestResponseMockerBuilderTemplate(t *testing.T) {
+	mocker, err := mock.UnmarshalResponseMocker([]byte(`{
+		"response_mocker": "ResponseMockerBuilder",
+		"status_code": 200,
+		"template": true,
+		"header": {
+			"X-Request-Id": ["{{.Header.Get \"X-Request-Id\"}}"],
+			"X-Ulid": ["{{ulid}}"]
+		},
+		"body": "{\"path\":\"{{.Path}}\",\"q\":\"{{.Query.Get \"q\"}}\",\"user\":\"{{.JSON.Get \"user.id\"}}\",\"name\":\"{{gjson .Body \"user.name\"}}\",\"rand\":\"{{randomString 6}}\"}"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq, _ := http.NewRequest("POST", "http://localhost/users?q=abc", strings.NewReader(`{"user":{"id":42,"name":"yf"}}`))
+	rq.Header.Set("X-Request-Id", "rid-1")
+	rp, err := mocker.Mock(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if !strings.HasPrefix(string(body), `{"path":"/users","q":"abc","user":"42","name":"yf","rand":"`) {
+		t.Fatalf("unexpected body %s", body)
+	}
+	if rp.Header.Get("X-Request-Id") != "rid-1" || len(rp.Header.Get("X-Ulid")) != 26 {
+		t.Fatalf("unexpected header %v", rp.Header)
+	}
+	reqBody, _ := ioutil.ReadAll(rq.Body)
+	if string(reqBody) != `{"user":{"id":42,"name":"yf"}}` {
+		t.Fatalf("request body should be restored, got %s", reqBody)
+	}
+
+	// NOTE: without template flag body is returned as is
+	mocker, _ = mock.UnmarshalResponseMocker([]byte(`{"response_mocker": "ResponseMockerBuilder", "body": "{{.Path}}"}`))
+	rp, _ = mocker.Mock(rq)
+	body, _ = ioutil.ReadAll(rp.Body)
+	if string(body) != "{{.Path}}" {
+		t.Fatalf("should not render, got %s", body)
+	}
+
+	// NOTE: 模板语法错误在加载时报错
+	for _, data := range []string{
+		`{"response_mocker": "ResponseMockerBuilder", "template": true, "body": "{{.Path"}`,
+		`{"response_mocker": "ResponseMockerBuilder", "template": true, "header": {"X-A": ["{{unknown}}"]}}`,
+	} {
+		if _, err = mock.UnmarshalResponseMocker([]byte(data)); err == nil {
+			t.Fatalf("%s should fail to load", data)
+		}
+	}
+
+	// NOTE: random的max<=min时返回错误而不是panic
+	mocker, err = mock.UnmarshalResponseMocker([]byte(`{"response_mocker": "ResponseMockerBuilder", "template": true, "body": "{{random 5 5}}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mocker.Mock(rq); err == nil {
+		t.Fatal("random with max <= min should fail")
+	}
+}
@@ -0,0 +1,384 @@
+package mock
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Transformer 可选接口，透明ResponseMocker实现此接口时，Transport和Handler会将源服务的真实响应交给Transform修改后再返回
+type Transformer interface {
+	Transform(r *http.Request, rp *http.Response) (*http.Response, error)
+}
+
+// ResponseEdit 操作类型
+const (
+	EditSet          = "set"           // 设置json字段，字段或中间对象不存在时创建
+	EditDelete       = "delete"        // 删除json字段，字段不存在时忽略
+	EditSetHeader    = "set_header"    // 设置响应头
+	EditAddHeader    = "add_header"    // 追加响应头
+	EditRemoveHeader = "remove_header" // 删除响应头
+	EditStatus       = "status"        // 覆盖状态码
+)
+
+// ResponseEdit 对真实响应的一次修改
+// 1. set/delete: path以`/`开头时为JSON Pointer，如`/data/items/0/name`，否则为gjson风格的点分路径，如`data.items.0.name`，`\.`转义点号，数组下标为`-`或等于数组长度时set追加元素;
+// 2. set_header/add_header/remove_header: name为响应头名称，value为字符串;
+// 3. status: value为状态码
+type ResponseEdit struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// TransformResponseMocker 转换Mocker，与TransparentResponseMocker一样从源服务获取真实响应，然后按Edits依次修改后返回，
+// 用于只需微调真实响应的场景，如修改某个json字段、注入响应头或强制状态码
+type TransformResponseMocker struct {
+	*Options `json:"options"`
+	Edits    []*ResponseEdit `json:"edits"`
+}
+
+func (mr *TransformResponseMocker) ID() string {
+	return "TransformResponseMocker"
+}
+
+func (mr *TransformResponseMocker) New() ResponseMocker {
+	return new(TransformResponseMocker)
+}
+
+func (mr *TransformResponseMocker) IsTransparent() bool {
+	return true
+}
+
+// Mock 转换Mocker需要真实响应，因此只能通过Transport或Handler使用
+func (mr *TransformResponseMocker) Mock(*http.Request) (*http.Response, error) {
+	return nil, errors.Errorf("%s should be used with Transport or Handler", mr.ID())
+}
+
+func (mr *TransformResponseMocker) Extension() *Options {
+	return mr.Options
+}
+
+// UnmarshalJSON 解析后校验Edits，便于尽早发现配置错误
+func (mr *TransformResponseMocker) UnmarshalJSON(data []byte) error {
+	type plain TransformResponseMocker
+	err := json.Unmarshal(data, (*plain)(mr))
+	if err != nil {
+		return err
+	}
+	for i, edit := range mr.Edits {
+		err = edit.validate()
+		if err != nil {
+			return errors.WithMessagef(err, "invalid edit %d", i)
+		}
+	}
+	return nil
+}
+
+// Transform 实现Transformer，按顺序应用Edits
+func (mr *TransformResponseMocker) Transform(r *http.Request, rp *http.Response) (*http.Response, error) {
+	if rp.Header == nil {
+		rp.Header = http.Header{}
+	}
+	var doc interface{}
+	var bodyDecoded, bodyEdited bool
+	for i, edit := range mr.Edits {
+		err := edit.validate()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid edit %d", i)
+		}
+		switch edit.Op {
+		case EditSet, EditDelete:
+			if !bodyDecoded {
+				doc, bodyEdited, err = decodeJSONBody(rp)
+				if err != nil {
+					return nil, errors.WithMessagef(err, "transform response of %s failed", r.URL)
+				}
+				bodyDecoded = true
+			}
+			if !bodyEdited {
+				// NOTE: 非json Body(如上游的html错误页)跳过json修改，保留真实响应
+				continue
+			}
+			if edit.Op == EditSet {
+				var value interface{}
+				_ = unmarshalJSONNumber(edit.Value, &value) // NOTE: validate已校验
+				doc, err = setJSONPath(doc, splitJSONPath(edit.Path), value)
+			} else {
+				doc, err = deleteJSONPath(doc, splitJSONPath(edit.Path))
+			}
+			if err != nil {
+				return nil, errors.WithMessagef(err, "%s %s failed", edit.Op, edit.Path)
+			}
+		case EditSetHeader:
+			rp.Header.Set(edit.Name, edit.stringValue())
+		case EditAddHeader:
+			rp.Header.Add(edit.Name, edit.stringValue())
+		case EditRemoveHeader:
+			rp.Header.Del(edit.Name)
+		case EditStatus:
+			statusCode, _ := strconv.Atoi(string(edit.Value))
+			rp.StatusCode, rp.Status = statusCode, strconv.Itoa(statusCode)
+		}
+	}
+	if bodyEdited {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(doc)
+		if err != nil {
+			return nil, errors.WithMessage(err, "marshal transformed body failed")
+		}
+		body := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		rp.Body = NewResponseBodyFromBytes(body)
+		rp.ContentLength = int64(len(body))
+		rp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return rp, nil
+}
+
+func (edit *ResponseEdit) validate() error {
+	switch edit.Op {
+	case EditSet:
+		var value interface{}
+		if err := unmarshalJSONNumber(edit.Value, &value); err != nil {
+			return errors.WithMessage(err, "set requires a json value")
+		}
+		fallthrough
+	case EditDelete:
+		if edit.Path == "" || edit.Path == "/" {
+			return errors.Errorf("%s requires a non-empty path", edit.Op)
+		}
+	case EditSetHeader, EditAddHeader:
+		var s string
+		if err := json.Unmarshal(edit.Value, &s); err != nil {
+			return errors.Errorf("%s requires a string value", edit.Op)
+		}
+		fallthrough
+	case EditRemoveHeader:
+		if edit.Name == "" {
+			return errors.Errorf("%s requires a header name", edit.Op)
+		}
+	case EditStatus:
+		statusCode, err := strconv.Atoi(string(edit.Value))
+		if err != nil || statusCode < 100 || statusCode > 999 {
+			return errors.Errorf("status requires a valid status code, got %s", edit.Value)
+		}
+	default:
+		return errors.Errorf("unknown op %q", edit.Op)
+	}
+	return nil
+}
+
+func (edit *ResponseEdit) stringValue() string {
+	var s string
+	_ = json.Unmarshal(edit.Value, &s)
+	return s
+}
+
+// decodeJSONBody 读取并解析json响应Body，gzip编码时先解压并删除Content-Encoding
+// NOTE: Body不是json时回填原始Body并返回false，响应保持不变
+func decodeJSONBody(rp *http.Response) (interface{}, bool, error) {
+	var body []byte
+	if rp.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(rp.Body)
+		rp.Body.Close()
+		if err != nil {
+			return nil, false, errors.WithMessage(err, "read body failed")
+		}
+	}
+	raw := body
+	gzipped := strings.EqualFold(rp.Header.Get("Content-Encoding"), "gzip")
+	if gzipped {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err == nil {
+			body, err = ioutil.ReadAll(zr)
+		}
+		if err != nil {
+			rp.Body = NewResponseBodyFromBytes(raw)
+			return nil, false, nil
+		}
+	}
+	var doc interface{}
+	err := unmarshalJSONNumber(body, &doc)
+	if err != nil {
+		rp.Body = NewResponseBodyFromBytes(raw)
+		return nil, false, nil
+	}
+	if gzipped {
+		rp.Header.Del("Content-Encoding")
+	}
+	return doc, true, nil
+}
+
+// unmarshalJSONNumber 解析json，数字保留为json.Number以免精度丢失
+func unmarshalJSONNumber(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after json value")
+	}
+	return nil
+}
+
+// splitJSONPath 将JSON Pointer或gjson风格的点分路径拆分为字段序列
+func splitJSONPath(path string) []string {
+	if strings.HasPrefix(path, "/") {
+		tokens := strings.Split(path[1:], "/")
+		for i, token := range tokens {
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		}
+		return tokens
+	}
+	var (
+		tokens []string
+		token  strings.Builder
+	)
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			token.WriteByte(path[i])
+		case path[i] == '.':
+			tokens = append(tokens, token.String())
+			token.Reset()
+		default:
+			token.WriteByte(path[i])
+		}
+	}
+	return append(tokens, token.String())
+}
+
+// setJSONPath 设置doc中path对应的值，返回修改后的doc
+func setJSONPath(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case nil:
+		if _, err := strconv.Atoi(token); err == nil || token == "-" {
+			return setJSONPath([]interface{}{}, path, value)
+		}
+		return setJSONPath(map[string]interface{}{}, path, value)
+	case map[string]interface{}:
+		child, err := setJSONPath(node[token], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		i := len(node)
+		if token != "-" {
+			var err error
+			i, err = strconv.Atoi(token)
+			if err != nil || i < 0 || i > len(node) {
+				return nil, errors.Errorf("invalid array index %s", token)
+			}
+		}
+		if i == len(node) {
+			node = append(node, nil)
+		}
+		child, err := setJSONPath(node[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, errors.Errorf("cannot set field %s of a scalar value", token)
+	}
+}
+
+// deleteJSONPath 删除doc中path对应的值，不存在时忽略，返回修改后的doc
+func deleteJSONPath(doc interface{}, path []string) (interface{}, error) {
+	token, rest := path[0], path[1:]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			delete(node, token)
+			return node, nil
+		}
+		if child, ok := node[token]; ok {
+			child, err := deleteJSONPath(child, rest)
+			if err != nil {
+				return nil, err
+			}
+			node[token] = child
+		}
+		return node, nil
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(node) {
+			return node, nil
+		}
+		if len(rest) == 0 {
+			return append(node[:i], node[i+1:]...), nil
+		}
+		child, err := deleteJSONPath(node[i], rest)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return doc, nil
+	}
+}
+
+// bufferResponseWriter 缓存写入的状态码、头和Body而不写出，用于Handler修改上游响应
+type bufferResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *bufferResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *bufferResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *bufferResponseWriter) Response() *http.Response {
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode),
+		StatusCode:    statusCode,
+		Header:        w.header,
+		Body:          NewResponseBodyFromBytes(w.body.Bytes()),
+		ContentLength: int64(w.body.Len()),
+	}
+}
+
+var (
+	_ ResponseMocker   = (*TransformResponseMocker)(nil)
+	_ Transformer      = (*TransformResponseMocker)(nil)
+	_ json.Unmarshaler = (*TransformResponseMocker)(nil)
+)
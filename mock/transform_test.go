@@ -0,0 +1,108 @@
+package mock_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ccmonky/pkg/mock"
+)
+
+const testTransformMocker = `{
+	"response_mocker": "TransformResponseMocker",
+	"edits": [
+		{"op": "set", "path": "data.user.name", "value": "mock"},
+		{"op": "set", "path": "/data/items/-", "value": {"id": 3}},
+		{"op": "set", "path": "data.new\\.field", "value": true},
+		{"op": "delete", "path": "/data/token"},
+		{"op": "delete", "path": "data.items.0"},
+		{"op": "set_header", "name": "X-Mock", "value": "transformed"},
+		{"op": "remove_header", "name": "X-Upstream"},
+		{"op": "status", "value": 503}
+	]
+}`
+
+const testTransformedBody = `{"data":{"items":[{"id":2},{"id":3}],"new.field":true,"user":{"id":12345678901234567890,"name":"mock"}}}`
+
+func TestTransformResponseMocker(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "real")
+		body := `{"data": {"user": {"id": 12345678901234567890, "name": "real"}, "token": "secret", "items": [{"id": 1}, {"id": 2}]}}`
+		if r.URL.Path == "/html" {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, "<html>bad gateway</html>")
+			return
+		}
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, body)
+			zw.Close()
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	matcher := newEigenkeyMatcher(t, nil, map[string]string{"/plain": testTransformMocker, "/gzip": testTransformMocker, "/html": testTransformMocker})
+
+	check := func(name string, rp *http.Response) {
+		body, _ := ioutil.ReadAll(rp.Body)
+		if rp.StatusCode != 503 || string(body) != testTransformedBody {
+			t.Fatalf("%s got %d %s", name, rp.StatusCode, body)
+		}
+		if rp.Header.Get("X-Mock") != "transformed" || rp.Header.Get("X-Upstream") != "" || rp.Header.Get("Content-Encoding") != "" {
+			t.Fatalf("%s got header %v", name, rp.Header)
+		}
+	}
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	rp, err := client.Get(upstream.URL + "/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("transport", rp)
+
+	ts := httptest.NewServer(mock.NewHandler(matcher, target))
+	defer ts.Close()
+	for _, path := range []string{"/plain", "/gzip"} {
+		rq, _ := http.NewRequest("GET", ts.URL+path, nil)
+		rq.Header.Set("Accept-Encoding", "identity")
+		rp, err = http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("handler"+path, rp)
+	}
+
+	// NOTE: 非json Body跳过json修改，其余修改照常生效
+	rp, err = client.Get(upstream.URL + "/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if rp.StatusCode != 503 || string(body) != "<html>bad gateway</html>" || rp.Header.Get("X-Mock") != "transformed" {
+		t.Fatalf("non-json body should pass through, got %d %s %v", rp.StatusCode, body, rp.Header)
+	}
+}
+
+func TestTransformResponseMockerInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"response_mocker": "TransformResponseMocker", "edits": [{"op": "unknown"}]}`,
+		`{"response_mocker": "TransformResponseMocker", "edits": [{"op": "set", "path": "a", "value": }]}`,
+		`{"response_mocker": "TransformResponseMocker", "edits": [{"op": "set", "value": 1}]}`,
+		`{"response_mocker": "TransformResponseMocker", "edits": [{"op": "set_header", "name": "X-A", "value": 1}]}`,
+		`{"response_mocker": "TransformResponseMocker", "edits": [{"op": "status", "value": "ok"}]}`,
+	} {
+		_, err := mock.UnmarshalResponseMocker([]byte(data))
+		if err == nil {
+			t.Fatalf("%s should fail", data)
+		}
+	}
+}
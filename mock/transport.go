@@ -0,0 +1,157 @@
+package mock
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/ccmonky/pkg/utils"
+)
+
+// Transport 基于Matcher的http.RoundTripper，可直接用于http.Client而无需修改调用方代码
+// 1. 匹配到非透明ResponseMocker时，返回其Mock响应;
+// 2. 未匹配或匹配到透明ResponseMocker时，转发给Base，透明ResponseMocker实现了Recorder时录制真实响应，实现了Transformer时修改真实响应;
+// 3. 匹配到的ResponseMocker的Options指定了延迟和故障注入时，按其设置延迟、重置连接、返回错误状态码、截断或限速Body
+type Transport struct {
+	Matcher Matcher
+	Base    http.RoundTripper // NOTE: 为nil时使用http.DefaultTransport
+
+	// ErrorLog 记录不影响响应的错误(如录制失败)，为nil时使用log包的标准logger
+	ErrorLog *log.Logger
+}
+
+// NewTransport 新建Transport
+func NewTransport(matcher Matcher, base http.RoundTripper) *Transport {
+	return &Transport{
+		Matcher: matcher,
+		Base:    base,
+	}
+}
+
+// RoundTrip 实现http.RoundTripper
+// NOTE: 按http.RoundTripper的约定不修改调用方的请求，匹配、Mock和转发均使用请求的副本
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	key, mocker, err := matchRequest(t.Matcher, r)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "match mock for %s failed", r.URL)
+	}
+	if mocker == nil {
+		return t.base().RoundTrip(r)
+	}
+	r = r.WithContext(WithEigenkey(r.Context(), key))
+	mocker, options, err := Resolve(mocker, r)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "resolve mock for %s failed", r.URL)
+	}
+	err = options.Wait(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	if options.ShouldAbort() {
+		return nil, ErrConnectionReset
+	}
+	var rp *http.Response
+	switch {
+	case options.ShouldError():
+		rp = options.ErrorResponse()
+	case mocker.IsTransparent():
+		rp, err = t.roundTripTransparent(key, mocker, r)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		rp, err = mocker.Mock(r)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "mock response for %s failed", r.URL)
+		}
+	}
+	rp = fixResponse(rp, req)
+	rp.Body = options.WrapBody(r.Context(), rp.Body)
+	return rp, nil
+}
+
+// matchRequest 使用matcher匹配请求，matcher需要读取Body时先缓存Body，匹配后回填
+// NOTE: Body超过utils.DefaultMaxBodySize时视为未匹配，不缓存Body，r.Body仍可读取完整的原始流
+func matchRequest(matcher Matcher, r *http.Request) (string, ResponseMocker, error) {
+	if bm, ok := matcher.(BodyMatcher); ok && !bm.NeedBody(r) {
+		return matcher.Match(r)
+	}
+	body, err := utils.ReadAndRestoreBody(r)
+	if errors.Is(err, utils.ErrBodyTooLarge) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	key, mocker, err := matcher.Match(r)
+	// NOTE: 计算特征值可能执行了ParseForm，需回填Body
+	utils.RestoreBody(r, body)
+	return key, mocker, err
+}
+
+// roundTripTransparent 转发给Base，如果mocker实现了Recorder则录制真实响应，实现了Transformer则修改真实响应
+func (t *Transport) roundTripTransparent(key string, mocker ResponseMocker, r *http.Request) (*http.Response, error) {
+	rp, err := t.base().RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if recorder, ok := mocker.(Recorder); ok {
+		// NOTE: 边读边录制，不阻塞流式响应；录制失败不影响响应，与Handler一致仅记录日志
+		teeRecording(rp, func(captured *http.Response, err error) {
+			if err == nil {
+				err = recorder.Record(key, captured)
+			}
+			if err != nil {
+				t.logf("mock: record response for %s failed: %v", r.URL, err)
+			}
+		})
+	}
+	if transformer, ok := mocker.(Transformer); ok {
+		rp, err = transformer.Transform(r, rp)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "transform response for %s failed", r.URL)
+		}
+	}
+	return rp, nil
+}
+
+func (t *Transport) logf(format string, args ...interface{}) {
+	if t.ErrorLog != nil {
+		t.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// fixResponse 补全Mock响应中http.Client依赖的字段
+func fixResponse(rp *http.Response, r *http.Request) *http.Response {
+	if rp.Body == nil {
+		rp.Body = http.NoBody
+	}
+	if rp.Header == nil {
+		rp.Header = http.Header{}
+	}
+	if rp.Proto == "" {
+		rp.Proto, rp.ProtoMajor, rp.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+	if rp.Status == "" || rp.Status == strconv.Itoa(rp.StatusCode) {
+		rp.Status = fmt.Sprintf("%d %s", rp.StatusCode, http.StatusText(rp.StatusCode))
+	}
+	rp.Request = r
+	return rp
+}
+
+var (
+	_ http.RoundTripper = (*Transport)(nil)
+)
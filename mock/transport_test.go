@@ -0,0 +1,131 @@
+package mock_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccmonky/pkg/eigenkey"
+	"github.com/ccmonky/pkg/mock"
+	"github.com/ccmonky/pkg/utils"
+)
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		io.WriteString(w, "real:"+r.PostForm.Get("a"))
+	}))
+	defer ts.Close()
+
+	matcher := newEigenkeyMatcher(t, &eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{
+			UseMethod:    true,
+			UsePath:      true,
+			UseArguments: []string{"a"},
+		},
+	}, nil)
+	matcher.Set("GET:/mocked", &mock.ResponseMockerBuilder{
+		Options:    &mock.Options{Latency: utils.Duration{Duration: 20 * time.Millisecond}},
+		StatusCode: 201,
+		Body:       "mocked",
+	})
+	matcher.Set("POST:/transparent?a=1", new(mock.TransparentResponseMocker))
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+
+	start := time.Now()
+	rp, err := client.Get(ts.URL + "/mocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if rp.StatusCode != 201 || string(body) != "mocked" {
+		t.Fatalf("should be mocked, got %d %s", rp.StatusCode, body)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("latency should be applied")
+	}
+
+	for _, path := range []string{"/transparent", "/unmatched"} {
+		rp, err = client.PostForm(ts.URL+path, url.Values{"a": []string{"1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(rp.Body)
+		if string(body) != "real:1" {
+			t.Fatalf("%s should fall through with body refilled, got %s", path, body)
+		}
+	}
+
+	rp, err = client.Post(ts.URL+"/mocked", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(rp.Body)
+	if string(body) != "real:" {
+		t.Fatalf("should fall through, got %s", body)
+	}
+	// NOTE: 不修改调用方的请求
+	rq, _ := http.NewRequest("POST", ts.URL+"/transparent", strings.NewReader("a=1"))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	reqBody := rq.Body
+	rp, err = mock.NewTransport(matcher, nil).RoundTrip(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(rp.Body)
+	if string(body) != "real:1" {
+		t.Fatalf("should fall through with body, got %s", body)
+	}
+	if rq.Form != nil || rq.PostForm != nil || rq.Body != reqBody || rp.Request != rq {
+		t.Fatal("request of caller should not be modified")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransportRequestBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	// NOTE: Matcher不需要Body时不缓存，原始Body直接转发
+	rq, _ := http.NewRequest("POST", ts.URL+"/upload", strings.NewReader("12345"))
+	reqBody := rq.Body
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Body != reqBody {
+			t.Fatal("body should be forwarded without buffering")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	rp, err := mock.NewTransport(newEigenkeyMatcher(t, nil, nil), base).RoundTrip(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Body.Close()
+
+	// NOTE: Body超过上限时视为未匹配，转发完整的Body
+	defer func(n int64) { utils.DefaultMaxBodySize = n }(utils.DefaultMaxBodySize)
+	utils.DefaultMaxBodySize = 4
+	matcher := newEigenkeyMatcher(t, &eigenkey.HTTPRequestEigenkeyExtractor{
+		RequestExtractor: &eigenkey.HTTPRequestExtractor{UsePath: true, UseArguments: []string{"a"}},
+	}, map[string]string{"/upload?a=123": `{"response_mocker": "ResponseMockerBuilder", "body": "mocked"}`})
+	client := &http.Client{Transport: mock.NewTransport(matcher, nil)}
+	rp, err = client.PostForm(ts.URL+"/upload", url.Values{"a": []string{"123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(rp.Body)
+	if string(body) != "a=123" {
+		t.Fatalf("oversized body should be forwarded unchanged, got %s", body)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"net/http"
 	"strings"
@@ -72,6 +73,58 @@ func TryBufReadByte(r *http.Request) (b byte, err error) {
 	return
 }
 
+var (
+	// DefaultMaxBodySize ReadAndRestoreBody读取Body的上限，与http.Request.ParseForm的表单上限一致
+	DefaultMaxBodySize int64 = 10 << 20
+
+	// ErrBodyTooLarge 请求Body超过读取上限
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// ReadAndRestoreBody 读取请求Body并回填，后续处理器仍可读取完整的Body，Body为nil或http.NoBody时返回nil
+// NOTE: 最多读取DefaultMaxBodySize字节，见ReadAndRestoreBodyLimit
+func ReadAndRestoreBody(r *http.Request) ([]byte, error) {
+	return ReadAndRestoreBodyLimit(r, DefaultMaxBodySize)
+}
+
+// ReadAndRestoreBodyLimit 同ReadAndRestoreBody，最多读取limit字节，limit<=0时不限制
+// NOTE: 超过limit时返回前limit字节及ErrBodyTooLarge，已读取的部分与未读取的Body一起回填，下游仍可流式读取完整的Body
+func ReadAndRestoreBodyLimit(r *http.Request, limit int64) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("nil http request")
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	var reader io.Reader = r.Body
+	if limit > 0 {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+	body, err := io.ReadAll(reader)
+	if limit > 0 && int64(len(body)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return body[:limit], errors.WithMessagef(ErrBodyTooLarge, "limit %d bytes", limit)
+	}
+	r.Body.Close()
+	RestoreBody(r, body)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read request body failed")
+	}
+	return body, nil
+}
+
+// RestoreBody 使用body回填r.Body，body为nil时设为http.NoBody
+func RestoreBody(r *http.Request, body []byte) {
+	if body == nil {
+		r.Body = http.NoBody
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+}
+
 var methods = map[string]struct{}{
 	http.MethodGet:     struct{}{},
 	http.MethodHead:    struct{}{},
@@ -2,6 +2,7 @@ package utils_test
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -163,3 +164,39 @@ func BenchmarkTryRead(b *testing.B) {
 		utils.TryRead(r)
 	}
 }
+
+func TestReadAndRestoreBody(t *testing.T) {
+	r, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("12345"))
+	assert.Nilf(t, err, "new request err")
+	body, err := utils.ReadAndRestoreBody(r)
+	assert.Nilf(t, err, "read and restore body err")
+	assert.Equalf(t, "12345", string(body), "body")
+	data, err := io.ReadAll(r.Body)
+	assert.Nilf(t, err, "read all err")
+	assert.Equalf(t, "12345", string(data), "restored body")
+
+	r, _ = http.NewRequest(http.MethodGet, "/", nil)
+	body, err = utils.ReadAndRestoreBody(r)
+	assert.Nilf(t, err, "read and restore nil body err")
+	assert.Nilf(t, body, "nil body")
+
+	_, err = utils.ReadAndRestoreBody(nil)
+	assert.NotNilf(t, err, "nil request")
+
+	r, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("12345"))
+	body, err = utils.ReadAndRestoreBodyLimit(r, 4)
+	assert.Truef(t, errors.Is(err, utils.ErrBodyTooLarge), "body too large")
+	assert.Equalf(t, "1234", string(body), "truncated body when too large")
+	data, _ = io.ReadAll(r.Body)
+	assert.Equalf(t, "12345", string(data), "restored body when too large")
+
+	r, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("12345"))
+	body, err = utils.ReadAndRestoreBodyLimit(r, 5)
+	assert.Nilf(t, err, "body equals limit")
+	assert.Equalf(t, "12345", string(body), "body equals limit")
+
+	r, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("12345"))
+	body, err = utils.ReadAndRestoreBodyLimit(r, 0)
+	assert.Nilf(t, err, "no limit")
+	assert.Equalf(t, "12345", string(body), "no limit")
+}
@@ -10,6 +10,9 @@ import (
 // ParseCertificateFromPEM parse certificate from pem data
 func ParseCertificateFromPEM(data []byte) (*x509.Certificate, error) {
 	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
 	var cert *x509.Certificate
 	var err error
 	cert, err = x509.ParseCertificate(block.Bytes)